@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"math"
+	"os"
+	"sort"
+
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// Allocation strategies decide which warehouses ship an order line:
+//   - nearest: the nearest warehouse holding enough for the whole line
+//   - most_stock: the warehouse holding the most of the item
+//   - split: nearest first, splitting the line across warehouses as needed
+//
+// Lines no single warehouse can cover are split, nearest first for nearest
+// and largest first for most_stock, rather than rejected.
+const (
+	allocateNearest   = "nearest"
+	allocateMostStock = "most_stock"
+	allocateSplit     = "split"
+)
+
+// allocationStrategy reads the checkout strategy from CHECKOUT_ALLOCATION
+func allocationStrategy() string {
+	switch s := os.Getenv("CHECKOUT_ALLOCATION"); s {
+	case allocateMostStock, allocateSplit:
+		return s
+	default:
+		return allocateNearest
+	}
+}
+
+// geoPoint is a shipping destination
+type geoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// allocation is the part of an order line shipped from one warehouse
+type allocation struct {
+	WarehouseID uint
+	Quantity    int
+}
+
+// allocateLine spreads quantity over the stock levels of one item. The levels
+// must hold at least quantity in total.
+func allocateLine(strategy string, levels []models.StockLevel, warehouses map[uint]models.Warehouse, quantity int, destination *geoPoint) []allocation {
+	candidates := make([]models.StockLevel, 0, len(levels))
+	for _, level := range levels {
+		if level.Quantity > 0 {
+			candidates = append(candidates, level)
+		}
+	}
+
+	if strategy == allocateMostStock {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Quantity != candidates[j].Quantity {
+				return candidates[i].Quantity > candidates[j].Quantity
+			}
+			return warehouseCloser(warehouses[candidates[i].WarehouseID], warehouses[candidates[j].WarehouseID], destination)
+		})
+	} else {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return warehouseCloser(warehouses[candidates[i].WarehouseID], warehouses[candidates[j].WarehouseID], destination)
+		})
+	}
+
+	if strategy != allocateSplit {
+		for _, level := range candidates {
+			if level.Quantity >= quantity {
+				return []allocation{{WarehouseID: level.WarehouseID, Quantity: quantity}}
+			}
+		}
+	}
+
+	var allocations []allocation
+	for _, level := range candidates {
+		if quantity == 0 {
+			break
+		}
+		take := min(level.Quantity, quantity)
+		allocations = append(allocations, allocation{WarehouseID: level.WarehouseID, Quantity: take})
+		quantity -= take
+	}
+	return allocations
+}
+
+// warehouseCloser orders warehouses by distance to the destination, placing
+// those without coordinates last, then by priority and ID
+func warehouseCloser(a, b models.Warehouse, destination *geoPoint) bool {
+	if destination != nil {
+		aLocated := a.Latitude != nil && a.Longitude != nil
+		bLocated := b.Latitude != nil && b.Longitude != nil
+		if aLocated != bLocated {
+			return aLocated
+		}
+		if aLocated {
+			da := distanceKm(*destination, geoPoint{*a.Latitude, *a.Longitude})
+			db := distanceKm(*destination, geoPoint{*b.Latitude, *b.Longitude})
+			if da != db {
+				return da < db
+			}
+		}
+	}
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	return a.ID < b.ID
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(a, b geoPoint) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLon := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
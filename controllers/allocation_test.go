@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestAllocateLine(t *testing.T) {
+	coords := func(lat, lon float64) (*float64, *float64) { return &lat, &lon }
+	berlinLat, berlinLon := coords(52.52, 13.405)
+	munichLat, munichLon := coords(48.137, 11.575)
+	warehouses := map[uint]models.Warehouse{
+		1: {ID: 1, Code: "BER", Latitude: berlinLat, Longitude: berlinLon, Priority: 1},
+		2: {ID: 2, Code: "MUC", Latitude: munichLat, Longitude: munichLon, Priority: 1},
+		// No coordinates, but first by priority
+		3: {ID: 3, Code: "BACK", Priority: 0},
+		4: {ID: 4, Code: "EMPTY", Priority: 0},
+	}
+	levels := []models.StockLevel{
+		{WarehouseID: 1, Quantity: 3},
+		{WarehouseID: 2, Quantity: 10},
+		{WarehouseID: 3, Quantity: 4},
+		{WarehouseID: 4, Quantity: 0},
+	}
+	hamburg := &geoPoint{Latitude: 53.551, Longitude: 9.993}
+
+	tests := []struct {
+		name        string
+		strategy    string
+		quantity    int
+		destination *geoPoint
+		want        []allocation
+	}{
+		{"nearest covers the line", allocateNearest, 2, hamburg, []allocation{{1, 2}}},
+		{"nearest skips a warehouse holding too little", allocateNearest, 5, hamburg, []allocation{{2, 5}}},
+		{"nearest without destination goes by priority", allocateNearest, 2, nil, []allocation{{3, 2}}},
+		{"nearest falls back to splitting, unlocated last", allocateNearest, 17, hamburg, []allocation{{1, 3}, {2, 10}, {3, 4}}},
+		{"nearest fallback without destination", allocateNearest, 15, nil, []allocation{{3, 4}, {1, 3}, {2, 8}}},
+		{"most stock ignores distance", allocateMostStock, 2, hamburg, []allocation{{2, 2}}},
+		{"most stock falls back to largest first", allocateMostStock, 12, nil, []allocation{{2, 10}, {3, 2}}},
+		{"split takes the nearest first", allocateSplit, 5, hamburg, []allocation{{1, 3}, {2, 2}}},
+		{"split within one warehouse", allocateSplit, 2, hamburg, []allocation{{1, 2}}},
+		{"split without destination goes by priority", allocateSplit, 6, nil, []allocation{{3, 4}, {1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocateLine(tt.strategy, levels, warehouses, tt.quantity, tt.destination)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocateLine(%s, %d) = %v, want %v", tt.strategy, tt.quantity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateLineLeavesLevelsAlone(t *testing.T) {
+	levels := []models.StockLevel{{WarehouseID: 2, Quantity: 1}, {WarehouseID: 1, Quantity: 5}}
+	warehouses := map[uint]models.Warehouse{1: {ID: 1}, 2: {ID: 2}}
+
+	allocateLine(allocateMostStock, levels, warehouses, 3, nil)
+	if levels[0].WarehouseID != 2 || levels[1].WarehouseID != 1 {
+		t.Errorf("allocateLine reordered the caller's levels: %v", levels)
+	}
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+)
+
+const apiKeyPrefix = "gmk_"
+
+// CreateAPIKey issues a new API key. The plaintext key is only returned here.
+func CreateAPIKey(c *fiber.Ctx) error {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+	}
+
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if len(input.Permissions) == 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "At least one permission is required"})
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Expiry must be in the future"})
+	}
+
+	// A key can never do more than the admin who created it
+	allowed, err := middleware.HasPermissions(user.Role, input.Permissions...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot grant permissions you do not hold"})
+	}
+
+	var perms []models.Permission
+	if err := database.DB.Where("name IN ?", input.Permissions).Find(&perms).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+
+	secret, err := utils.RandomToken(24)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := models.APIKey{
+		Name:        strings.TrimSpace(input.Name),
+		Prefix:      rawKey[:len(apiKeyPrefix)+8],
+		KeyHash:     utils.HashToken(rawKey),
+		Permissions: perms,
+		CreatedByID: user.UserID,
+		ExpiresAt:   input.ExpiresAt,
+	}
+	if err := database.DB.Create(&key).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create API key"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": rawKey, "api_key": key})
+}
+
+// GetAPIKeys lists all API keys without their secrets
+func GetAPIKeys(c *fiber.Ctx) error {
+	var keys []models.APIKey
+	if err := database.DB.Preload("Permissions").Order("id").Find(&keys).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve API keys"})
+	}
+	return c.JSON(keys)
+}
+
+// RevokeAPIKey stops an API key from authenticating
+func RevokeAPIKey(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid API key ID"})
+	}
+
+	res := database.DB.Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke API key"})
+	}
+	if res.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,32 +1,54 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/pranavpatil6/go_mart/database"
 	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func GetAllUsers(c *fiber.Ctx) error {
-	var users []models.User
-	result := database.DB.Find(&users)
-	if result.Error != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve users",
-		})
+const defaultBcryptCost = 14
+
+// bcryptCost reads the hashing cost from BCRYPT_COST, so tests can run cheap
+// and production can be tuned without a release
+func bcryptCost() int {
+	cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return defaultBcryptCost
 	}
+	return cost
+}
+
+const (
+	// maxFailedLogins is how many wrong passwords an account tolerates before locking
+	maxFailedLogins = 5
+	baseLockout     = time.Minute
+	maxLockout      = time.Hour
+)
 
-	return c.JSON(users)
+// hashPassword returns the bcrypt hash of a plaintext password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+	return string(hash), err
 }
 
 func Register(c *fiber.Ctx) error {
 
-	var input models.User
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
 	if err := c.BodyParser(&input); err != nil {
 		return c.Status(422).JSON(fiber.Map{"error": "Invalid JSON input"})
 	}
@@ -35,21 +57,43 @@ func Register(c *fiber.Ctx) error {
 		return c.Status(422).JSON(fiber.Map{"error": "Missing required field"})
 	}
 
-	var exists models.User
-	if err := database.DB.Where("email = ?", input.Email).First(&exists).Error; err == nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Email already registered"})
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return c.Status(422).JSON(fiber.Map{"error": "Invalid email address"})
+	}
+
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(input.Password), 14)
+	hash, err := hashPassword(input.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create user"})
+	}
 	user := models.User{
 		Name:     input.Name,
-		Email:    input.Email,
-		Password: string(hash),
+		Email:    email,
+		Password: hash,
 		Role:     "user", // default role
 	}
-	if err := database.DB.Create(&user).Error; err != nil {
+
+	// The unique index on lower(email) decides races between concurrent sign-ups
+	var token string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		token, err = issueEmailVerification(tx, user.ID)
+		return err
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(400).JSON(fiber.Map{"error": "Email already registered"})
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create user"})
 	}
+	sendVerificationEmail(user.Email, token)
+
 	return c.Status(201).JSON(fiber.Map{"message": "User registered successfully"})
 }
 
@@ -66,26 +110,60 @@ func Login(c *fiber.Ctx) error {
 	}
 
 	var user models.User
-	if err := database.DB.Where("email = ?", input.Email).First(&user).Error; err != nil {
+	if err := database.DB.Where("lower(email) = ?", strings.ToLower(strings.TrimSpace(input.Email))).First(&user).Error; err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+
+	// A locked account gets the same answer as a wrong password, so lockouts
+	// do not reveal which emails are registered
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		recordFailedLogin(user.ID)
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
 	}
 
-	claims := jwt.MapClaims{
-		"id":    user.ID,
-		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 72).Unix(),
-		"role":     user.Role,
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		database.DB.Model(&user).Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil})
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtSecret := os.Getenv("JWT_SECRET")
-	t, err := token.SignedString([]byte(jwtSecret))
-	if err != nil {
-		return c.SendStatus(fiber.StatusInternalServerError)
+	// Upgrade hashes stored at an outdated cost while we have the plaintext
+	if cost, err := bcrypt.Cost([]byte(user.Password)); err == nil && cost < bcryptCost() {
+		if hash, err := hashPassword(input.Password); err == nil {
+			database.DB.Model(&user).Update("password", hash)
+		}
+	}
+
+	return completeLogin(c, user)
+}
+
+// recordFailedLogin bumps the user's failure counter and, past maxFailedLogins,
+// locks the account with a backoff that doubles on every further failure.
+// It returns the lock expiry when the account is now locked.
+func recordFailedLogin(userID uint) *time.Time {
+	var user models.User
+	err := database.DB.Model(&user).Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_login_count"}}}).
+		Where("id = ?", userID).
+		Update("failed_login_count", gorm.Expr("failed_login_count + 1")).Error
+	if err != nil || user.FailedLoginCount < maxFailedLogins {
+		return nil
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Success login", "data": t})
+
+	lockout := baseLockout << (user.FailedLoginCount - maxFailedLogins)
+	if lockout > maxLockout || lockout <= 0 {
+		lockout = maxLockout
+	}
+	lockedUntil := time.Now().Add(lockout)
+	database.DB.Model(&models.User{}).Where("id = ?", userID).Update("locked_until", lockedUntil)
+	return &lockedUntil
+}
+
+// accountLocked answers a second-factor attempt on a locked account. Login
+// itself never uses it: by then the caller has proven the password.
+func accountLocked(c *fiber.Ctx, until time.Time) error {
+	retryAfter := int(time.Until(until).Seconds()) + 1
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Account temporarily locked after too many failed logins"})
 }
@@ -4,27 +4,24 @@ import (
     "strconv"
 
     "github.com/gofiber/fiber/v2"
-    "github.com/golang-jwt/jwt/v5"
     "github.com/pranavpatil6/go_mart/database"
+    "github.com/pranavpatil6/go_mart/middleware"
     "github.com/pranavpatil6/go_mart/models"
+    "gorm.io/gorm"
 )
 
 func AddToCart(c *fiber.Ctx) error {
-    userClaims, ok := c.Locals("user").(jwt.MapClaims)
+    user, ok := middleware.CurrentUser(c)
     if !ok {
         return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
     }
-
-    userIDFloat, ok := userClaims["id"].(float64)
-    if !ok {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
-    }
-    userID := uint(userIDFloat)
+    userID := user.UserID
 
    
     var input struct {
-        ProductID uint `json:"product_id"`
-        Quantity  int  `json:"quantity"`
+        ProductID uint  `json:"product_id"`
+        VariantID *uint `json:"variant_id"`
+        Quantity  int   `json:"quantity"`
     }
     if err := c.BodyParser(&input); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON input"})
@@ -33,12 +30,30 @@ func AddToCart(c *fiber.Ctx) error {
         input.Quantity = 1 // Default minimum quantity
     }
 
-    // Verify product exists
+    // Verify product exists and is not archived
     var product models.Product
-    if err := database.DB.First(&product, input.ProductID).Error; err != nil {
+    if err := database.DB.Preload("Variants").First(&product, input.ProductID).Error; err != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
     }
 
+    // Products sold in variants must be added as a specific variant of that product
+    price := product.Price
+    if input.VariantID != nil {
+        var variant *models.Variant
+        for i := range product.Variants {
+            if product.Variants[i].ID == *input.VariantID {
+                variant = &product.Variants[i]
+                break
+            }
+        }
+        if variant == nil {
+            return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Variant does not belong to this product"})
+        }
+        price = variant.EffectivePrice(product)
+    } else if len(product.Variants) > 0 {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Choose a variant of this product"})
+    }
+
     // Get or create user's cart
     var cart models.Cart
     err := database.DB.Preload("Items").Where("user_id = ?", userID).First(&cart).Error
@@ -50,11 +65,11 @@ func AddToCart(c *fiber.Ctx) error {
         }
     }
 
-    // Check if product already in cart
+    // Check if product variant already in cart
     var cartItem models.CartItem
     found := false
     for _, item := range cart.Items {
-        if item.ProductID == input.ProductID {
+        if item.ProductID == input.ProductID && sameVariant(item.VariantID, input.VariantID) {
             cartItem = item
             found = true
             break
@@ -64,7 +79,7 @@ func AddToCart(c *fiber.Ctx) error {
     if found {
         // Update quantity and price
         cartItem.Quantity += input.Quantity
-        cartItem.Price = product.Price
+        cartItem.Price = price
         if err := database.DB.Save(&cartItem).Error; err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update cart item"})
         }
@@ -73,8 +88,9 @@ func AddToCart(c *fiber.Ctx) error {
         cartItem = models.CartItem{
             CartID:    cart.ID,
             ProductID: input.ProductID,
+            VariantID: input.VariantID,
             Quantity:  input.Quantity,
-            Price:     product.Price,
+            Price:     price,
         }
         if err := database.DB.Create(&cartItem).Error; err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to add item to cart"})
@@ -82,14 +98,15 @@ func AddToCart(c *fiber.Ctx) error {
     }
 
     // Recalculate and update cart total
-    if err := updateCartTotal(&cart); err != nil {
+    if err := updateCartTotal(database.DB, &cart); err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update cart total"})
     }
 
     // Reload cart with fresh data
-    if err := database.DB.Preload("Items.Product").First(&cart, cart.ID).Error; err != nil {
+    if err := database.DB.Preload("Items.Product", withArchived).Preload("Items.Variant").First(&cart, cart.ID).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch updated cart"})
     }
+    flagUnavailableItems(&cart)
 
     return c.JSON(cart)
 }
@@ -97,16 +114,11 @@ func AddToCart(c *fiber.Ctx) error {
 
 // RemoveCartItem removes a cart item by ID
 func RemoveCartItem(c *fiber.Ctx) error {
-    userClaims, ok := c.Locals("user").(jwt.MapClaims)
+    user, ok := middleware.CurrentUser(c)
     if !ok {
         return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
     }
-
-    userIDFloat, ok := userClaims["id"].(float64)
-    if !ok {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
-    }
-    userID := uint(userIDFloat)
+    userID := user.UserID
 
     // Cart item ID from URL
     idStr := c.Params("id")
@@ -135,7 +147,7 @@ func RemoveCartItem(c *fiber.Ctx) error {
     }
 
     // Update cart total
-    if err := updateCartTotal(&cart); err != nil {
+    if err := updateCartTotal(database.DB, &cart); err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update cart total"})
     }
 
@@ -144,29 +156,38 @@ func RemoveCartItem(c *fiber.Ctx) error {
 
 // ViewCart returns the user's full cart with items and product details
 func ViewCart(c *fiber.Ctx) error {
-    userClaims, ok := c.Locals("user").(jwt.MapClaims)
+    user, ok := middleware.CurrentUser(c)
     if !ok {
         return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
     }
-
-    userIDFloat, ok := userClaims["id"].(float64)
-    if !ok {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
-    }
-    userID := uint(userIDFloat)
+    userID := user.UserID
 
     var cart models.Cart
-    if err := database.DB.Preload("Items.Product").Where("user_id = ?", userID).First(&cart).Error; err != nil {
+    if err := database.DB.Preload("Items.Product", withArchived).Preload("Items.Variant").Where("user_id = ?", userID).First(&cart).Error; err != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cart not found"})
     }
+    flagUnavailableItems(&cart)
 
     return c.JSON(cart)
 }
 
+// withArchived is a Preload scope that also loads archived products
+func withArchived(db *gorm.DB) *gorm.DB {
+    return db.Unscoped()
+}
+
+// flagUnavailableItems marks cart lines whose product has been archived.
+// Such lines must be removed before checkout.
+func flagUnavailableItems(cart *models.Cart) {
+    for i := range cart.Items {
+        cart.Items[i].Unavailable = cart.Items[i].Product.ArchivedAt.Valid
+    }
+}
+
 // updateCartTotal recalculates the cart total price based on cart items
-func updateCartTotal(cart *models.Cart) error {
+func updateCartTotal(tx *gorm.DB, cart *models.Cart) error {
     var items []models.CartItem
-    if err := database.DB.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+    if err := tx.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
         return err
     }
 
@@ -176,5 +197,13 @@ func updateCartTotal(cart *models.Cart) error {
     }
 
     cart.Total = total
-    return database.DB.Save(cart).Error
+    return tx.Save(cart).Error
+}
+
+// sameVariant reports whether two optional variant IDs refer to the same variant
+func sameVariant(a, b *uint) bool {
+    if a == nil || b == nil {
+        return a == nil && b == nil
+    }
+    return *a == *b
 }
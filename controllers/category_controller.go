@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+)
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a category name into a URL-safe slug
+func slugify(name string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// categoryInput is the body of category create and update requests
+type categoryInput struct {
+	Name        *string `json:"name"`
+	Slug        *string `json:"slug"`
+	Description *string `json:"description"`
+	ParentID    *uint   `json:"parent_id"`
+}
+
+// categoryDescendantIDs returns the ID of a category and of every category nested below it
+func categoryDescendantIDs(db *gorm.DB, id uint) ([]uint, error) {
+	var ids []uint
+	err := db.Raw(`WITH RECURSIVE tree AS (
+			SELECT id FROM categories WHERE id = ?
+			UNION
+			SELECT categories.id FROM categories JOIN tree ON categories.parent_id = tree.id
+		) SELECT id FROM tree`, id).Scan(&ids).Error
+	return ids, err
+}
+
+// findCategory looks a category up by numeric ID or by slug. New slugs are
+// never all digits, but older ones may be, so a number that is not an ID is
+// tried as a slug.
+func findCategory(ref string) (models.Category, error) {
+	var category models.Category
+	if id, err := strconv.Atoi(ref); err == nil {
+		err := database.DB.First(&category, id).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return category, err
+		}
+	}
+	return category, database.DB.Where("slug = ?", ref).First(&category).Error
+}
+
+// GetCategoryTree returns all categories nested under their parents
+func GetCategoryTree(c *fiber.Ctx) error {
+	var categories []models.Category
+	if err := database.DB.Order("name").Find(&categories).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve categories"})
+	}
+
+	children := map[uint][]models.Category{}
+	var roots []models.Category
+	for _, cat := range categories {
+		if cat.ParentID == nil {
+			roots = append(roots, cat)
+		} else {
+			children[*cat.ParentID] = append(children[*cat.ParentID], cat)
+		}
+	}
+
+	var attach func(cats []models.Category) []models.Category
+	attach = func(cats []models.Category) []models.Category {
+		for i := range cats {
+			cats[i].Children = attach(children[cats[i].ID])
+		}
+		return cats
+	}
+
+	return c.JSON(attach(roots))
+}
+
+// GetCategoryProducts lists products in a category, including its subcategories
+func GetCategoryProducts(c *fiber.Ctx) error {
+	category, err := findCategory(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	ids, err := categoryDescendantIDs(database.DB, category.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+	}
+
+	page := parsePageParams(c)
+	query := database.DB.Model(&models.Product{}).
+		Where("product_id IN (?)", database.DB.Table("product_categories").Select("product_id").Where("category_id IN ?", ids))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+	}
+
+	var products []models.Product
+	if err := query.Scopes(page.scope).Order("product_id DESC").Find(&products).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+	}
+
+	return sendPage(c, products, page, total)
+}
+
+// CreateCategory adds a category, optionally below a parent
+func CreateCategory(c *fiber.Ctx) error {
+	var input categoryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	var category models.Category
+	if msg := applyCategoryInput(&category, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	err := database.DB.Create(&category).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Slug already in use"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create category"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(category)
+}
+
+// UpdateCategory renames or moves a category
+func UpdateCategory(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid category ID"})
+	}
+
+	var category models.Category
+	if err := database.DB.First(&category, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	var input categoryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := applyCategoryInput(&category, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	err = database.DB.Save(&category).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Slug already in use"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update category"})
+	}
+
+	return c.JSON(category)
+}
+
+// DeleteCategory removes a category that has no subcategories
+func DeleteCategory(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid category ID"})
+	}
+
+	var children int64
+	database.DB.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children)
+	if children > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Move or delete the subcategories first"})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Category{}, id).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete category"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetProductCategories replaces the categories a product belongs to
+func SetProductCategories(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var product models.Product
+	if err := database.DB.First(&product, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	var input struct {
+		CategoryIDs []uint `json:"category_ids"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var categories []models.Category
+	if len(input.CategoryIDs) > 0 {
+		if err := database.DB.Where("id IN ?", input.CategoryIDs).Find(&categories).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update categories"})
+		}
+		if len(categories) != len(input.CategoryIDs) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Unknown category"})
+		}
+	}
+
+	if err := database.DB.Model(&product).Association("Categories").Replace(categories); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update categories"})
+	}
+	product.Categories = categories
+
+	return c.JSON(product)
+}
+
+// applyCategoryInput copies the provided fields onto category, returning a
+// validation message when the input is not acceptable
+func applyCategoryInput(category *models.Category, input categoryInput) string {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return "Name cannot be empty"
+		}
+		category.Name = name
+	}
+	if input.Slug != nil {
+		category.Slug = slugify(*input.Slug)
+	}
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	if category.Slug == "" {
+		return "Slug cannot be empty"
+	}
+	if strings.Trim(category.Slug, "0123456789") == "" {
+		// It would be read as a category ID
+		return "Slug cannot be only digits"
+	}
+	if input.Description != nil {
+		category.Description = *input.Description
+	}
+
+	if input.ParentID != nil {
+		if *input.ParentID == 0 {
+			category.ParentID = nil
+			return ""
+		}
+		var parent models.Category
+		if err := database.DB.First(&parent, *input.ParentID).Error; err != nil {
+			return "Parent category not found"
+		}
+		if category.ID != 0 {
+			// A category cannot be moved below itself or one of its descendants
+			descendants, err := categoryDescendantIDs(database.DB, category.ID)
+			if err != nil {
+				return "Failed to check category tree"
+			}
+			for _, d := range descendants {
+				if d == parent.ID {
+					return "A category cannot be nested inside itself"
+				}
+			}
+		}
+		category.ParentID = &parent.ID
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestApplyCategoryInputSlug(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		input    categoryInput
+		wantSlug string
+		wantMsg  string
+	}{
+		{"slug from name", categoryInput{Name: str("Desk Lamps")}, "desk-lamps", ""},
+		{"explicit slug", categoryInput{Name: str("Lamps"), Slug: str("Lighting & Lamps")}, "lighting-lamps", ""},
+		{"digits in a slug", categoryInput{Name: str("2024 Collection")}, "2024-collection", ""},
+		{"all-digit name", categoryInput{Name: str("2024")}, "", "Slug cannot be only digits"},
+		{"all-digit slug", categoryInput{Name: str("New"), Slug: str("2024")}, "", "Slug cannot be only digits"},
+		{"empty slug", categoryInput{Name: str("!!!")}, "", "Slug cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var category models.Category
+			msg := applyCategoryInput(&category, tt.input)
+			if msg != tt.wantMsg {
+				t.Fatalf("message = %q, want %q", msg, tt.wantMsg)
+			}
+			if msg == "" && category.Slug != tt.wantSlug {
+				t.Errorf("slug = %q, want %q", category.Slug, tt.wantSlug)
+			}
+		})
+	}
+}
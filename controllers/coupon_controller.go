@@ -5,8 +5,8 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
 	"github.com/pranavpatil6/go_mart/models"
 )
 
@@ -26,6 +26,11 @@ func CreateCoupon(c *fiber.Ctx) error {
 	if coupon.UsageLimit <= 0 {
 		coupon.UsageLimit = 100
 	}
+	if coupon.CategoryId != 0 {
+		if err := database.DB.First(&models.Category{}, coupon.CategoryId).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Category not found"})
+		}
+	}
 	if coupon.Expirydate.IsZero() {
 		coupon.Expirydate = time.Now().AddDate(0, 1, 0) 
 	}
@@ -40,11 +45,18 @@ func CreateCoupon(c *fiber.Ctx) error {
 }
 
 func GetCoupons(c *fiber.Ctx) error {
+	page := parsePageParams(c)
+
+	var total int64
+	if err := database.DB.Model(&models.Coupon{}).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch coupons"})
+	}
+
 	var coupons []models.Coupon
-	if err := database.DB.Find(&coupons).Error; err != nil {
+	if err := database.DB.Scopes(page.scope).Order("coupon_id").Find(&coupons).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch coupons"})
 	}
-	return c.JSON(coupons)
+	return sendPage(c, coupons, page, total)
 }
 
 func GetCouponByCode(c *fiber.Ctx) error {
@@ -61,15 +73,11 @@ func GetCouponByCode(c *fiber.Ctx) error {
 }
 
 func ApplyCoupon(c *fiber.Ctx) error {
-	userClaims, ok := c.Locals("user").(jwt.MapClaims)
+	user, ok := middleware.CurrentUser(c)
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
 	}
-	userIDFloat, ok := userClaims["id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
-	}
-	userID := uint(userIDFloat)
+	userID := user.UserID
 
 	var input struct {
 		Code string `json:"code"`
@@ -102,19 +110,27 @@ func ApplyCoupon(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cart total does not meet minimum value for coupon"})
 	}
 
+	eligibleTotal, err := couponEligibleTotal(coupon, cart.Items, cartTotal)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to apply coupon"})
+	}
+	if eligibleTotal <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Coupon does not apply to any item in your cart"})
+	}
+
 	var discountAmount float64
 	switch coupon.Type {
 	case "percent":
-		discountAmount = (float64(coupon.Discount) / 100) * cartTotal
+		discountAmount = (float64(coupon.Discount) / 100) * eligibleTotal
 	case "fixed":
 		discountAmount = float64(coupon.Discount)
 	default:
 		discountAmount = 0
 	}
 
-	// Discount should not exceed the cart total
-	if discountAmount > cartTotal {
-		discountAmount = cartTotal
+	// Discount should not exceed the value of the items it applies to
+	if discountAmount > eligibleTotal {
+		discountAmount = eligibleTotal
 	}
 
 
@@ -126,6 +142,42 @@ func ApplyCoupon(c *fiber.Ctx) error {
 	})
 }
 
+// couponEligibleTotal returns the value of the cart lines a coupon applies to.
+// Unscoped coupons apply to the whole cart; scoped ones to their product
+// and/or to products in their category tree.
+func couponEligibleTotal(coupon models.Coupon, items []models.CartItem, cartTotal float64) (float64, error) {
+	if coupon.ProductId == 0 && coupon.CategoryId == 0 {
+		return cartTotal, nil
+	}
+
+	eligible := map[uint]bool{}
+	if coupon.ProductId != 0 {
+		eligible[uint(coupon.ProductId)] = true
+	}
+	if coupon.CategoryId != 0 {
+		categoryIDs, err := categoryDescendantIDs(database.DB, coupon.CategoryId)
+		if err != nil {
+			return 0, err
+		}
+		var productIDs []uint
+		if err := database.DB.Table("product_categories").Where("category_id IN ?", categoryIDs).
+			Distinct().Pluck("product_id", &productIDs).Error; err != nil {
+			return 0, err
+		}
+		for _, id := range productIDs {
+			eligible[id] = true
+		}
+	}
+
+	var total float64
+	for _, item := range items {
+		if eligible[item.ProductID] {
+			total += float64(item.Quantity) * item.Price
+		}
+	}
+	return total, nil
+}
+
 func DeleteCoupon(c *fiber.Ctx) error {
 	idStr := c.Params("id")
 	if idStr == "" {
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+var errInvalidEmail = errors.New("invalid email address")
+var errInvalidVerificationToken = errors.New("invalid email verification token")
+
+// normalizeEmail validates a bare email address and returns it trimmed and lower-cased
+func normalizeEmail(raw string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", errInvalidEmail
+	}
+	return email, nil
+}
+
+// checkoutRequiresVerifiedEmail reports whether unverified users are blocked from checkout
+func checkoutRequiresVerifiedEmail() bool {
+	return os.Getenv("REQUIRE_VERIFIED_EMAIL_FOR_CHECKOUT") == "true"
+}
+
+// issueEmailVerification invalidates older verification links for a user and
+// stores a new one, returning the plaintext token to mail out
+func issueEmailVerification(tx *gorm.DB, userID uint) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Model(&models.EmailVerification{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error; err != nil {
+		return "", err
+	}
+	verification := models.EmailVerification{
+		UserID:    userID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}
+	if err := tx.Create(&verification).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func sendVerificationEmail(email, token string) {
+	sendMail(email, "Verify your GO-MART email",
+		"Use this link to verify your email address. It expires in 48 hours.\n\n"+appLink("/verify-email", token))
+}
+
+// VerifyEmail marks a user's email as verified using the emailed token
+func VerifyEmail(c *fiber.Ctx) error {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token is required"})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var verification models.EmailVerification
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(input.Token), time.Now()).
+			First(&verification).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&verification).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", verification.UserID).Update("email_verified_at", now).Error
+	})
+
+	if errors.Is(err, errInvalidVerificationToken) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify email"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Email verified"})
+}
+
+// ResendVerification emails a new verification link to the logged-in user
+func ResendVerification(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	if user.EmailVerifiedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already verified"})
+	}
+
+	token, err := issueEmailVerification(database.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to send verification email"})
+	}
+	sendVerificationEmail(user.Email, token)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Verification email sent"})
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errNegativeStock = errors.New("stock cannot go below zero")
+var errStockItemNotFound = errors.New("product or variant not found")
+
+// stockActor identifies who caused a stock movement
+type stockActor struct {
+	Type string
+	ID   *uint
+}
+
+var systemActor = stockActor{Type: "system"}
+
+// requestActor returns the API key or user behind the request
+func requestActor(c *fiber.Ctx) stockActor {
+	if key, ok := middleware.CurrentAPIKey(c); ok {
+		id := key.ID
+		return stockActor{Type: "api_key", ID: &id}
+	}
+	if user, ok := middleware.CurrentUser(c); ok {
+		id := user.UserID
+		return stockActor{Type: "user", ID: &id}
+	}
+	return systemActor
+}
+
+// moveStock adds movement.Quantity to the stock of its product, or of its
+// variant, in the movement's warehouse (the default one when unset), and
+// records the movement in the ledger. Every stock change goes through here
+// so that stock always equals the sum of the ledger and of the warehouse
+// stock levels.
+func moveStock(tx *gorm.DB, movement *models.InventoryMovement, actor stockActor) error {
+	if movement.Quantity == 0 {
+		return nil
+	}
+	if movement.WarehouseID == nil {
+		id, err := defaultWarehouseID(tx)
+		if err != nil {
+			return err
+		}
+		movement.WarehouseID = &id
+	}
+
+	// Transfers leave the total alone, but still lock the row so that
+	// they serialize with other movements of the same stock
+	totalDelta := movement.Quantity
+	if movement.Type == models.MovementTransfer {
+		totalDelta = 0
+	}
+
+	// Archived products keep their ledger, so they are included
+	var balance, threshold int
+	var res *gorm.DB
+	returning := clause.Returning{Columns: []clause.Column{{Name: "stock"}, {Name: "reorder_threshold"}}}
+	if movement.VariantID != nil {
+		var variant models.Variant
+		res = tx.Model(&variant).Clauses(returning).
+			Where("id = ? AND product_id = ? AND stock + ? >= 0", *movement.VariantID, movement.ProductID, totalDelta).
+			Update("stock", gorm.Expr("stock + ?", totalDelta))
+		balance, threshold = variant.Stock, variant.ReorderThreshold
+	} else {
+		var product models.Product
+		res = tx.Unscoped().Model(&product).Clauses(returning).
+			Where("product_id = ? AND stock + ? >= 0", movement.ProductID, totalDelta).
+			Update("stock", gorm.Expr("stock + ?", totalDelta))
+		balance, threshold = product.Stock, product.ReorderThreshold
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return stockItemMissingOrShort(tx, movement)
+	}
+	if err := adjustStockLevel(tx, movement); err != nil {
+		return err
+	}
+
+	movement.BalanceAfter = balance
+	movement.ActorType = actor.Type
+	movement.ActorID = actor.ID
+	if err := tx.Create(movement).Error; err != nil {
+		return err
+	}
+	if movement.Type == models.MovementTransfer {
+		return nil
+	}
+	return queueStockEvents(tx, movement, threshold)
+}
+
+// adjustStockLevel applies a movement to the stock level of its warehouse,
+// refusing to take it below zero
+func adjustStockLevel(tx *gorm.DB, movement *models.InventoryMovement) error {
+	var level models.StockLevel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("warehouse_id = ? AND product_id = ? AND variant_id IS NOT DISTINCT FROM ?", *movement.WarehouseID, movement.ProductID, movement.VariantID).
+		First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if movement.Quantity < 0 {
+			return errNegativeStock
+		}
+		level = models.StockLevel{
+			WarehouseID: *movement.WarehouseID,
+			ProductID:   movement.ProductID,
+			VariantID:   movement.VariantID,
+			Quantity:    movement.Quantity,
+		}
+		return tx.Create(&level).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if level.Quantity+movement.Quantity < 0 {
+		return errNegativeStock
+	}
+	return tx.Model(&level).Update("quantity", level.Quantity+movement.Quantity).Error
+}
+
+// withVariantStock sets the Stock of products sold in variants to the total
+// of their variants. Their own stock column is not used and stays 0.
+func withVariantStock(db *gorm.DB, products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(products))
+	for i, p := range products {
+		ids[i] = p.ProductId
+	}
+	var totals []struct {
+		ProductID uint
+		Stock     int
+	}
+	if err := db.Model(&models.Variant{}).Select("product_id, sum(stock) AS stock").
+		Where("product_id IN ?", ids).Group("product_id").Scan(&totals).Error; err != nil {
+		return err
+	}
+	stock := make(map[uint]int, len(totals))
+	for _, t := range totals {
+		stock[t.ProductID] = t.Stock
+	}
+	for i := range products {
+		if total, ok := stock[products[i].ProductId]; ok {
+			products[i].Stock = total
+		}
+	}
+	return nil
+}
+
+// sellableStock is the stock of a product, or of one of its variants, that
+// checkout can sell: what active warehouses hold
+func sellableStock(tx *gorm.DB, productID uint, variantID *uint) (int, error) {
+	var stock int
+	err := tx.Model(&models.StockLevel{}).
+		Joins("JOIN warehouses ON warehouses.id = stock_levels.warehouse_id AND warehouses.active").
+		Where("stock_levels.product_id = ? AND stock_levels.variant_id IS NOT DISTINCT FROM ?", productID, variantID).
+		Select("coalesce(sum(stock_levels.quantity), 0)").Scan(&stock).Error
+	return stock, err
+}
+
+// defaultWarehouseID returns the warehouse that takes stock not booked to a
+// specific one
+func defaultWarehouseID(tx *gorm.DB) (uint, error) {
+	var warehouse models.Warehouse
+	err := tx.Where("is_default").Order("id").First(&warehouse).Error
+	return warehouse.ID, err
+}
+
+// stockItemMissingOrShort explains why moveStock updated no row
+func stockItemMissingOrShort(tx *gorm.DB, movement *models.InventoryMovement) error {
+	var count int64
+	var err error
+	if movement.VariantID != nil {
+		err = tx.Model(&models.Variant{}).Where("id = ? AND product_id = ?", *movement.VariantID, movement.ProductID).Count(&count).Error
+	} else {
+		err = tx.Unscoped().Model(&models.Product{}).Where("product_id = ?", movement.ProductID).Count(&count).Error
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errStockItemNotFound
+	}
+	return errNegativeStock
+}
+
+// manualMovementSigns lists the movement types staff may post and the sign
+// applied to their quantity. Adjustments carry their own sign; sales are
+// only recorded by checkout.
+var manualMovementSigns = map[string]int{
+	models.MovementReceipt:    1,
+	models.MovementReturn:     1,
+	models.MovementDamage:     -1,
+	models.MovementAdjustment: 0,
+}
+
+// CreateStockMovement records a receipt, return, damage or adjustment for a
+// product or one of its variants, in the given warehouse or the default one
+func CreateStockMovement(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var product models.Product
+	if err := database.DB.Unscoped().Preload("Variants").First(&product, productID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	var input struct {
+		Type        string `json:"type"`
+		Quantity    int    `json:"quantity"`
+		Reason      string `json:"reason"`
+		VariantID   *uint  `json:"variant_id"`
+		WarehouseID *uint  `json:"warehouse_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	sign, ok := manualMovementSigns[input.Type]
+	switch {
+	case !ok:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Type must be receipt, return, damage or adjustment"})
+	case strings.TrimSpace(input.Reason) == "":
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Reason is required"})
+	case sign == 0 && input.Quantity == 0:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Adjustment quantity cannot be zero"})
+	case sign != 0 && input.Quantity <= 0:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Quantity must be positive"})
+	}
+	if sign != 0 {
+		input.Quantity *= sign
+	}
+
+	// Products sold in variants keep stock per variant
+	if input.VariantID == nil && len(product.Variants) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Choose a variant of this product"})
+	}
+	if input.WarehouseID != nil {
+		if err := database.DB.First(&models.Warehouse{}, *input.WarehouseID).Error; err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Warehouse not found"})
+		}
+	}
+
+	movement := models.InventoryMovement{
+		ProductID:   product.ProductId,
+		VariantID:   input.VariantID,
+		WarehouseID: input.WarehouseID,
+		Type:        input.Type,
+		Quantity:    input.Quantity,
+		Reason:      strings.TrimSpace(input.Reason),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		return moveStock(tx, &movement, requestActor(c))
+	})
+
+	switch {
+	case err == nil:
+		return c.Status(fiber.StatusCreated).JSON(movement)
+	case errors.Is(err, errStockItemNotFound):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Variant does not belong to this product"})
+	case errors.Is(err, errNegativeStock):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Stock cannot go below zero"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record stock movement"})
+	}
+}
+
+// GetStockMovements lists a product's stock history, newest first.
+// ?variant_id= narrows it to one variant.
+func GetStockMovements(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	page := parsePageParams(c)
+	query := database.DB.Model(&models.InventoryMovement{}).Where("product_id = ?", productID)
+	if v := c.Query("variant_id"); v != "" {
+		variantID, err := strconv.Atoi(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid variant_id"})
+		}
+		query = query.Where("variant_id = ?", variantID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get stock history"})
+	}
+
+	movements := []models.InventoryMovement{}
+	if err := query.Scopes(page.scope).Order("id DESC").Find(&movements).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get stock history"})
+	}
+
+	return sendPage(c, movements, page, total)
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pranavpatil6/go_mart/mailer"
+)
+
+// appURL builds a link into the frontend at APP_URL
+func appURL(path string) string {
+	base := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return base + path
+}
+
+// appLink builds a link into the frontend carrying a token
+func appLink(path, token string) string {
+	return appURL(path) + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail delivers an email, logging instead of failing the request when delivery fails
+func sendMail(to, subject, body string) {
+	if err := mailer.Mail.Send(to, subject, body); err != nil {
+		log.Printf("failed to send %q to %s: %v", subject, to, err)
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestMain connects to the Postgres database in TEST_DB_DSN. Tests that need
+// a database are skipped when it is unset. The database is wiped between
+// tests, so never point it at real data.
+func TestMain(m *testing.M) {
+	if os.Getenv("JWT_SECRET") == "" {
+		os.Setenv("JWT_SECRET", "test-secret")
+	}
+	if dsn := os.Getenv("TEST_DB_DSN"); dsn != "" {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+		if err != nil {
+			log.Fatal("Failed to connect to test database: ", err)
+		}
+		database.DB = db
+		if err := database.Migrate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// requireDB skips the test when no test database is configured, and
+// otherwise empties the tables tests write to. Roles and the default
+// warehouse seeded by migrations are kept.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if database.DB == nil {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+	err := database.DB.Exec(`TRUNCATE users, sessions, products, variants, carts, cart_items, orders, order_items,
+		inventory_movements, stock_levels, stock_events, stock_subscriptions, user_identities, oidc_login_states,
+		email_changes, password_resets, email_verifications, recovery_codes
+		RESTART IDENTITY CASCADE`).Error
+	if err == nil {
+		err = database.DB.Exec("DELETE FROM warehouses WHERE code <> 'MAIN'").Error
+	}
+	if err == nil {
+		err = database.DB.Exec("UPDATE warehouses SET is_default = true, active = true WHERE code = 'MAIN'").Error
+	}
+	if err != nil {
+		t.Fatalf("failed to reset test database: %v", err)
+	}
+}
+
+// createTestUser stores a verified user with role and returns it with an
+// MFA-verified access token
+func createTestUser(t *testing.T, email, role string) (models.User, string) {
+	t.Helper()
+	now := time.Now()
+	user := models.User{Name: email, Email: email, Role: role, EmailVerifiedAt: &now}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	tokens, err := startSession(database.DB, user, true)
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	return user, tokens.AccessToken
+}
+
+// createTestProduct stores a product with stock received into the default
+// warehouse
+func createTestProduct(t *testing.T, stock int) models.Product {
+	t.Helper()
+	product := models.Product{Title: "Test product", Price: 10}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		receipt := models.InventoryMovement{ProductID: product.ProductId, Type: models.MovementReceipt, Quantity: stock, Reason: "test stock"}
+		return moveStock(tx, &receipt, systemActor)
+	})
+	if err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	product.Stock = stock
+	return product
+}
+
+// addToTestCart puts quantity of a product in the user's cart
+func addToTestCart(t *testing.T, userID, productID uint, quantity int) {
+	t.Helper()
+	cart := models.Cart{UserID: userID, Total: float64(quantity) * 10}
+	if err := database.DB.Where("user_id = ?", userID).FirstOrCreate(&cart).Error; err != nil {
+		t.Fatalf("failed to create cart: %v", err)
+	}
+	item := models.CartItem{CartID: cart.ID, ProductID: productID, Quantity: quantity, Price: 10}
+	if err := database.DB.Create(&item).Error; err != nil {
+		t.Fatalf("failed to add cart item: %v", err)
+	}
+}
+
+// doRequest sends a request to app, as the holder of token when one is
+// given, and returns the status and body. It is safe to call from other
+// goroutines: failures are reported with t.Errorf and a zero status.
+func doRequest(t *testing.T, app *fiber.App, method, path, token string, body interface{}) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Errorf("failed to encode request body: %v", err)
+			return 0, nil
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Errorf("%s %s failed: %v", method, path, err)
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("failed to read response of %s %s: %v", method, path, err)
+		return 0, nil
+	}
+	return resp.StatusCode, data
+}
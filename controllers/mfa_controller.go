@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaPurpose        = "mfa"
+	mfaPendingTTL     = 5 * time.Minute
+	totpIssuer        = "GO-MART"
+	recoveryCodeCount = 10
+	// mfaEnrollLoginWindow is how recent a login must be for an account
+	// without a password to enroll in MFA
+	mfaEnrollLoginWindow = 10 * time.Minute
+)
+
+// completeLogin finishes a first-factor login. Users with MFA enabled get a
+// short-lived "mfa pending" token to redeem at /auth/mfa/verify; everyone else
+// gets a session straight away.
+func completeLogin(c *fiber.Ctx, user models.User) error {
+	if user.MFAEnabledAt != nil {
+		claims := middleware.NewClaims(user.ID, user.Email, user.Role, 0, mfaPendingTTL)
+		claims.Purpose = mfaPurpose
+		t, err := middleware.SignToken(claims)
+		if err != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.JSON(fiber.Map{"status": "mfa_required", "message": "Enter the code from your authenticator app", "mfa_token": t})
+	}
+
+	tokens, err := startSession(database.DB, user, false)
+	if err != nil {
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(fiber.Map{"status": "success", "message": "Success login", "data": tokens})
+}
+
+// VerifyMFA redeems an mfa pending token with a TOTP or recovery code
+func VerifyMFA(c *fiber.Ctx) error {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "MFA token is required"})
+	}
+
+	claims, err := middleware.ParseToken(input.MFAToken)
+	if err != nil || claims.Purpose != mfaPurpose {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, claims.UserID).Error; err != nil || user.MFAEnabledAt == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return accountLocked(c, *user.LockedUntil)
+	}
+
+	var verified bool
+	if input.RecoveryCode != "" {
+		verified, err = useRecoveryCode(user.ID, input.RecoveryCode)
+	} else {
+		verified, err = checkTOTP(&user, input.Code)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !verified {
+		if lockedUntil := recordFailedLogin(user.ID); lockedUntil != nil {
+			return accountLocked(c, *lockedUntil)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		database.DB.Model(&user).Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil})
+	}
+
+	tokens, err := startSession(database.DB, user, true)
+	if err != nil {
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(fiber.Map{"status": "success", "message": "Success login", "data": tokens})
+}
+
+// EnrollMFA creates a new TOTP secret for the logged-in user after checking
+// their password, so a stolen access token cannot lock the owner out behind
+// someone else's authenticator. Accounts without a password need a login
+// made in the last mfaEnrollLoginWindow instead. MFA is not enforced until
+// the secret is confirmed with a valid code.
+func EnrollMFA(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	if user.MFAEnabledAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Password is incorrect"})
+		}
+	} else if !recentLogin(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Sign in again to enroll in two-factor authentication"})
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start enrollment"})
+	}
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start enrollment"})
+	}
+
+	return c.JSON(fiber.Map{"secret": secret, "otpauth_uri": utils.TOTPURI(totpIssuer, user.Email, secret)})
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator works. It
+// returns the recovery codes, which are never shown again, and a new token
+// pair for an MFA-verified session.
+func ConfirmMFA(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	if user.MFAEnabledAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+	if user.TOTPSecret == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Start enrollment first"})
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	verified, err := checkTOTP(&user, input.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !verified {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	var codes []string
+	var tokens tokenPair
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("mfa_enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		tokens, err = startSession(tx, user, true)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to enable two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{"recovery_codes": codes, "data": tokens})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	if user.MFAEnabledAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	verified, err := checkTOTP(&user, input.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !verified {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	codes, err := replaceRecoveryCodes(database.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate recovery codes"})
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// DisableMFA turns MFA off after re-checking the password and a TOTP code.
+// Accounts that only sign in externally have no password, so for them the
+// fresh, single-use TOTP code is the whole confirmation.
+func DisableMFA(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	if user.MFAEnabledAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Password is incorrect"})
+		}
+	}
+	verified, err := checkTOTP(&user, input.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !verified {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid authentication code"})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_secret": "", "totp_last_step": 0, "mfa_enabled_at": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to disable two-factor authentication"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// recentLogin reports whether the session behind the request was started,
+// not merely refreshed, within mfaEnrollLoginWindow
+func recentLogin(c *fiber.Ctx) bool {
+	claims, ok := middleware.CurrentUser(c)
+	if !ok {
+		return false
+	}
+	var session models.Session
+	if err := database.DB.First(&session, claims.SessionID).Error; err != nil {
+		return false
+	}
+	return time.Since(session.CreatedAt) < mfaEnrollLoginWindow
+}
+
+// checkTOTP validates a code for user and burns its time step so it cannot be replayed
+func checkTOTP(user *models.User, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	res := database.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	user.TOTPLastStep = step
+	return res.RowsAffected == 1, nil
+}
+
+// useRecoveryCode consumes one unused recovery code
+func useRecoveryCode(userID uint, code string) (bool, error) {
+	hash := utils.HashToken(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+	res := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return res.RowsAffected == 1, res.Error
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and issues a new set
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := utils.RandomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(raw)})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestEnrollMFAReauthenticates(t *testing.T) {
+	requireDB(t)
+
+	app := fiber.New()
+	app.Post("/me/mfa/enroll", middleware.JWTProtected(), EnrollMFA)
+
+	withPassword, token := createTestUser(t, "local@example.com", "user")
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Model(&withPassword).Update("password", string(hash))
+
+	if status, _ := doRequest(t, app, http.MethodPost, "/me/mfa/enroll", token, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("enrolling without the password got %d, want 401", status)
+	}
+	if status, _ := doRequest(t, app, http.MethodPost, "/me/mfa/enroll", token, fiber.Map{"password": "wrong"}); status != fiber.StatusUnauthorized {
+		t.Errorf("enrolling with a wrong password got %d, want 401", status)
+	}
+	if status, body := doRequest(t, app, http.MethodPost, "/me/mfa/enroll", token, fiber.Map{"password": "correct horse"}); status != fiber.StatusOK {
+		t.Errorf("enrolling with the password got %d: %s", status, body)
+	}
+
+	external, token := createTestUser(t, "external@example.com", "user")
+	if status, body := doRequest(t, app, http.MethodPost, "/me/mfa/enroll", token, nil); status != fiber.StatusOK {
+		t.Errorf("password-less enrollment right after login got %d: %s", status, body)
+	}
+	database.DB.Model(&models.Session{}).Where("user_id = ?", external.ID).
+		Update("created_at", time.Now().Add(-2*mfaEnrollLoginWindow))
+	if status, _ := doRequest(t, app, http.MethodPost, "/me/mfa/enroll", token, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("password-less enrollment on an old session got %d, want 401", status)
+	}
+}
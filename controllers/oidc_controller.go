@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/oidc"
+	"github.com/pranavpatil6/go_mart/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie ties a sign-in to the browser that started it, so a
+// callback URL from someone else's sign-in cannot log the victim in
+const oidcStateCookie = "oidc_state"
+
+var errOIDCEmailUnverified = errors.New("provider did not verify the email address")
+
+// OIDCStart redirects the browser to the configured identity provider
+func OIDCStart(c *fiber.Ctx) error {
+	provider := oidc.Default
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "External login is not configured"})
+	}
+
+	state, err1 := utils.RandomToken(16)
+	nonce, err2 := utils.RandomToken(16)
+	verifier, err3 := utils.RandomToken(32)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start login"})
+	}
+
+	login := models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if err := database.DB.Create(&login).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start login"})
+	}
+
+	url, err := provider.AuthCodeURL(c.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Identity provider unavailable"})
+	}
+
+	// Lax, so the cookie comes back on the provider's top-level redirect
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(url, fiber.StatusFound)
+}
+
+// OIDCCallback completes the provider login and issues GO-MART tokens
+func OIDCCallback(c *fiber.Ctx) error {
+	provider := oidc.Default
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "External login is not configured"})
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Login was cancelled or denied", "reason": errParam})
+	}
+
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing code or state"})
+	}
+	cookieState := c.Cookies(oidcStateCookie)
+	c.ClearCookie(oidcStateCookie)
+	if subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login state"})
+	}
+
+	// Each state is single-use
+	var login models.OIDCLoginState
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state_hash = ? AND expires_at > ?", utils.HashToken(state), time.Now()).
+			First(&login).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&login).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login state"})
+	}
+
+	claims, err := provider.Exchange(c.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "External login failed"})
+	}
+
+	var user models.User
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = userForIdentity(tx, provider.Issuer, claims)
+		return err
+	})
+	if errors.Is(err, errOIDCEmailUnverified) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Your provider has not verified this email address"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "External login failed"})
+	}
+
+	return completeLogin(c, user)
+}
+
+// userForIdentity finds the user linked to an external identity. Unknown
+// identities are linked to the user with the same verified email, or to a
+// newly created user when there is none. Linking to an account whose email
+// was never verified first strips its password, MFA and sessions: whoever
+// registered it did not prove they own the address.
+func userForIdentity(tx *gorm.DB, issuer string, claims *oidc.IDTokenClaims) (models.User, error) {
+	var user models.User
+
+	var identity models.UserIdentity
+	err := tx.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+	if err == nil {
+		err = tx.First(&user, identity.UserID).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if !claims.EmailVerified {
+		return user, errOIDCEmailUnverified
+	}
+	email, err := normalizeEmail(claims.Email)
+	if err != nil {
+		return user, errOIDCEmailUnverified
+	}
+
+	err = tx.Where("lower(email) = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		now := time.Now()
+		name := claims.Name
+		if name == "" {
+			name = email
+		}
+		// No password: the account can only sign in externally until one is set via reset
+		user = models.User{Name: name, Email: email, Role: "user", EmailVerifiedAt: &now}
+		err = tx.Create(&user).Error
+	} else if err == nil && user.EmailVerifiedAt == nil {
+		err = claimUnverifiedAccount(tx, &user)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	identity = models.UserIdentity{UserID: user.ID, Issuer: issuer, Subject: claims.Subject, Email: email}
+	return user, tx.Create(&identity).Error
+}
+
+// claimUnverifiedAccount hands an account registered with an unverified email
+// to the provider-verified owner of that email. Credentials set by whoever
+// registered it are removed, so they cannot keep access.
+func claimUnverifiedAccount(tx *gorm.DB, user *models.User) error {
+	now := time.Now()
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"email_verified_at": now,
+		"password":          "",
+		"totp_secret":       "",
+		"totp_last_step":    0,
+		"mfa_enabled_at":    nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.EmailVerifiedAt = &now
+	user.Password, user.TOTPSecret, user.TOTPLastStep, user.MFAEnabledAt = "", "", 0, nil
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	// A pending email change would move the account to an address they own
+	if err := tx.Model(&models.EmailChange{}).Where("user_id = ? AND used_at IS NULL", user.ID).Update("used_at", now).Error; err != nil {
+		return err
+	}
+	return revokeUserSessions(tx, user.ID)
+}
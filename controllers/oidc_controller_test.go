@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/oidc"
+	"github.com/pranavpatil6/go_mart/utils"
+)
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	previous := oidc.Default
+	oidc.Default = &oidc.Provider{Issuer: "https://idp.example", ClientID: "go-mart"}
+	t.Cleanup(func() { oidc.Default = previous })
+
+	app := fiber.New()
+	app.Get("/auth/oidc/callback", OIDCCallback)
+
+	tests := []struct {
+		name   string
+		cookie string
+	}{
+		{name: "no cookie"},
+		{name: "another browser's state", cookie: "someone-elses-state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=the-code&state=attacker-state", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("callback got %d, want 400", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOIDCLinkStripsUnverifiedAccountCredentials(t *testing.T) {
+	requireDB(t)
+
+	// Registered by someone who never proved they own the address
+	hash, err := hashPassword("attacker-password-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	squatter := models.User{Name: "squatter", Email: "victim@example.com", Password: hash, Role: "user", TOTPSecret: "SECRET", MFAEnabledAt: &now}
+	if err := database.DB.Create(&squatter).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startSession(database.DB, squatter, false); err != nil {
+		t.Fatal(err)
+	}
+	change := models.EmailChange{UserID: squatter.ID, NewEmail: "attacker@example.com", TokenHash: utils.HashToken("change"), ExpiresAt: now.Add(time.Hour)}
+	if err := database.DB.Create(&change).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &oidc.IDTokenClaims{Email: "victim@example.com", EmailVerified: true}
+	claims.Subject = "victim-subject"
+	user, err := userForIdentity(database.DB, "https://idp.example", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != squatter.ID {
+		t.Fatalf("linked to user %d, want %d", user.ID, squatter.ID)
+	}
+
+	var reloaded models.User
+	if err := database.DB.First(&reloaded, squatter.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Password != "" || reloaded.MFAEnabledAt != nil || reloaded.TOTPSecret != "" || reloaded.EmailVerifiedAt == nil {
+		t.Errorf("linked account kept the squatter's credentials: %+v", reloaded)
+	}
+	var active int64
+	database.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", squatter.ID).Count(&active)
+	if active != 0 {
+		t.Errorf("%d of the squatter's sessions are still active", active)
+	}
+	var pending int64
+	database.DB.Model(&models.EmailChange{}).Where("user_id = ? AND used_at IS NULL", squatter.ID).Count(&pending)
+	if pending != 0 {
+		t.Errorf("%d of the squatter's email changes are still pending", pending)
+	}
+}
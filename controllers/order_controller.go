@@ -1,79 +1,434 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
 	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"strconv"
 )
 
+var errCartNotFound = errors.New("cart not found")
+var errCartEmpty = errors.New("cart is empty")
+
+// stockShortage describes a single cart line that cannot be fulfilled
+type stockShortage struct {
+    ProductID uint  `json:"product_id"`
+    VariantID *uint `json:"variant_id,omitempty"`
+    Requested int   `json:"requested"`
+    Available int   `json:"available"`
+}
+
+type insufficientStockError struct {
+    Lines []stockShortage
+}
+
+func (e *insufficientStockError) Error() string {
+    return "insufficient stock"
+}
+
+// unavailableProductsError lists archived products still in the cart
+type unavailableProductsError struct {
+    ProductIDs []uint
+}
+
+func (e *unavailableProductsError) Error() string {
+    return "products no longer available"
+}
+
 // CreateOrder creates a new order for a user (checkout)
 func CreateOrder(c *fiber.Ctx) error {
-    type OrderInput struct {
-        UserID uint `json:"user_id"` // In production, get from JWT!
+    user, ok := middleware.CurrentUser(c)
+    if !ok {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+    }
+    userID := user.UserID
+
+    // The shipping location, when given, lets checkout ship from the nearest warehouse
+    var input struct {
+        Latitude  *float64 `json:"latitude"`
+        Longitude *float64 `json:"longitude"`
     }
-    var input OrderInput
-    if err := c.BodyParser(&input); err != nil {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+    if len(c.Body()) > 0 {
+        if err := c.BodyParser(&input); err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+        }
+    }
+    var destination *geoPoint
+    if input.Latitude != nil && input.Longitude != nil {
+        if *input.Latitude < -90 || *input.Latitude > 90 || *input.Longitude < -180 || *input.Longitude > 180 {
+            return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid latitude or longitude"})
+        }
+        destination = &geoPoint{Latitude: *input.Latitude, Longitude: *input.Longitude}
     }
 
-    // Get user's cart and items
-    var cart models.Cart
-    if err := database.DB.Preload("Items.Product").Where("user_id = ?", input.UserID).First(&cart).Error; err != nil {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cart not found"})
+    if checkoutRequiresVerifiedEmail() {
+        var account models.User
+        if err := database.DB.First(&account, userID).Error; err != nil || account.EmailVerifiedAt == nil {
+            return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Verify your email address before checking out"})
+        }
     }
-    if len(cart.Items) == 0 {
+
+    var order models.Order
+    err := database.DB.Transaction(func(tx *gorm.DB) error {
+        var err error
+        order, err = checkout(tx, userID, destination)
+        return err
+    })
+
+    var stockErr *insufficientStockError
+    var unavailableErr *unavailableProductsError
+    switch {
+    case err == nil:
+    case errors.Is(err, errCartNotFound):
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Cart not found"})
+    case errors.Is(err, errCartEmpty):
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cart is empty"})
+    case errors.As(err, &stockErr):
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Insufficient stock", "items": stockErr.Lines})
+    case errors.As(err, &unavailableErr):
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Some products in your cart are no longer available", "product_ids": unavailableErr.ProductIDs})
+    default:
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create order"})
+    }
+
+    return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+// checkout turns the user's cart into an order inside tx. The cart and every
+// product or variant row it references are locked FOR UPDATE, so concurrent
+// checkouts serialize on the same stock and can never oversell. Each line is
+// allocated to one or more active warehouses, recorded on its order items.
+func checkout(tx *gorm.DB, userID uint, destination *geoPoint) (models.Order, error) {
+    var cart models.Cart
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&cart).Error; err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return models.Order{}, errCartNotFound
+        }
+        return models.Order{}, err
+    }
+
+    var items []models.CartItem
+    if err := tx.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+        return models.Order{}, err
+    }
+    if len(items) == 0 {
+        return models.Order{}, errCartEmpty
+    }
+
+    // Sum quantities per product, or per variant for variant lines, and lock
+    // rows in id order (products before variants) to avoid deadlocks
+    productRequested := map[uint]int{}
+    variantRequested := map[uint]int{}
+    variantProduct := map[uint]uint{}
+    inCart := map[uint]int{}
+    for _, ci := range items {
+        inCart[ci.ProductID] += ci.Quantity
+        if ci.VariantID != nil {
+            variantRequested[*ci.VariantID] += ci.Quantity
+            variantProduct[*ci.VariantID] = ci.ProductID
+        } else {
+            productRequested[ci.ProductID] += ci.Quantity
+        }
+    }
+    productIDs := sortedKeys(productRequested)
+    variantIDs := sortedKeys(variantRequested)
+
+    var archived []uint
+    if err := tx.Unscoped().Model(&models.Product{}).Where("product_id IN ? AND archived_at IS NOT NULL", sortedKeys(inCart)).
+        Order("product_id").Pluck("product_id", &archived).Error; err != nil {
+        return models.Order{}, err
+    }
+    if len(archived) > 0 {
+        return models.Order{}, &unavailableProductsError{ProductIDs: archived}
+    }
+
+    if len(productIDs) > 0 {
+        var products []models.Product
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id IN ?", productIDs).Order("product_id").Find(&products).Error; err != nil {
+            return models.Order{}, err
+        }
+    }
+
+    variants := map[uint]models.Variant{}
+    if len(variantIDs) > 0 {
+        var locked []models.Variant
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", variantIDs).Order("id").Find(&locked).Error; err != nil {
+            return models.Order{}, err
+        }
+        for _, v := range locked {
+            variants[v.ID] = v
+        }
+    }
+
+    // Only stock held in active warehouses can be sold
+    warehouses, levels, err := lockStockLevels(tx, productIDs, variantIDs)
+    if err != nil {
+        return models.Order{}, err
+    }
+
+    var shortages []stockShortage
+    for _, id := range productIDs {
+        if available := levelsTotal(levels[stockKey{ProductID: id}]); productRequested[id] > available {
+            shortages = append(shortages, stockShortage{ProductID: id, Requested: productRequested[id], Available: available})
+        }
+    }
+    for _, id := range variantIDs {
+        variantID := id
+        // A variant listed under another product has no levels under this key
+        available := levelsTotal(levels[stockKey{ProductID: variantProduct[id], VariantID: id}])
+        if variantRequested[id] > available {
+            shortages = append(shortages, stockShortage{ProductID: variantProduct[id], VariantID: &variantID, Requested: variantRequested[id], Available: available})
+        }
+    }
+    if len(shortages) > 0 {
+        return models.Order{}, &insufficientStockError{Lines: shortages}
     }
 
-    // Create order items from cart items
+    // Create order items from cart items, one per warehouse shipping the line
+    strategy := allocationStrategy()
     var orderItems []models.OrderItem
     var total float64
-    for _, ci := range cart.Items {
-        oi := models.OrderItem{
-            ProductId: ci.ProductID,
-            Quantity:  ci.Quantity,
-            Price:     ci.Price,
+    for _, ci := range items {
+        key := stockKey{ProductID: ci.ProductID}
+        if ci.VariantID != nil {
+            key.VariantID = *ci.VariantID
+        }
+        for _, a := range allocateLine(strategy, levels[key], warehouses, ci.Quantity, destination) {
+            warehouseID := a.WarehouseID
+            oi := models.OrderItem{
+                ProductId:   ci.ProductID,
+                VariantId:   ci.VariantID,
+                WarehouseId: &warehouseID,
+                Quantity:    a.Quantity,
+                Price:       ci.Price,
+            }
+            if ci.VariantID != nil {
+                oi.SKU = variants[*ci.VariantID].SKU
+            }
+            orderItems = append(orderItems, oi)
+            takeFromLevels(levels[key], a)
         }
-        orderItems = append(orderItems, oi)
         total += float64(ci.Quantity) * ci.Price
     }
 
-    // Create new order
     order := models.Order{
-        UserId: input.UserID,
+        UserId: userID,
         Total:  total,
         Status: "pending",
         Items:  orderItems,
     }
-    if err := database.DB.Create(&order).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create order"})
+    if err := tx.Create(&order).Error; err != nil {
+        return models.Order{}, err
     }
 
-    // Optionally, clear user's cart after order
-    if err := database.DB.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err == nil {
-        cart.Total = 0
-        database.DB.Save(&cart)
+    // Take the sold quantities out of each allocated warehouse through the inventory ledger
+    customer := stockActor{Type: "user", ID: &userID}
+    reason := fmt.Sprintf("order #%d", order.Id)
+    for _, item := range order.Items {
+        sale := models.InventoryMovement{ProductID: item.ProductId, VariantID: item.VariantId, WarehouseID: item.WarehouseId, Type: models.MovementSale, Quantity: -item.Quantity, Reason: reason, OrderID: &order.Id}
+        if err := moveStock(tx, &sale, customer); err != nil {
+            return models.Order{}, err
+        }
     }
 
-    return c.Status(fiber.StatusCreated).JSON(order)
+    // Clear user's cart
+    if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+        return models.Order{}, err
+    }
+    cart.Total = 0
+    if err := tx.Save(&cart).Error; err != nil {
+        return models.Order{}, err
+    }
+
+    return order, nil
+}
+
+// stockKey identifies a product, or one of its variants, across warehouses
+type stockKey struct {
+    ProductID uint
+    VariantID uint
+}
+
+// lockStockLevels locks the levels of the given products and variants held in
+// active warehouses, grouped by item
+func lockStockLevels(tx *gorm.DB, productIDs, variantIDs []uint) (map[uint]models.Warehouse, map[stockKey][]models.StockLevel, error) {
+    var active []models.Warehouse
+    if err := tx.Where("active").Find(&active).Error; err != nil {
+        return nil, nil, err
+    }
+    warehouses := map[uint]models.Warehouse{}
+    warehouseIDs := []uint{}
+    for _, w := range active {
+        warehouses[w.ID] = w
+        warehouseIDs = append(warehouseIDs, w.ID)
+    }
+
+    var locked []models.StockLevel
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("warehouse_id IN ?", warehouseIDs).
+        Where("(variant_id IS NULL AND product_id IN ?) OR variant_id IN ?", productIDs, variantIDs).
+        Order("id").Find(&locked).Error
+    if err != nil {
+        return nil, nil, err
+    }
+
+    levels := map[stockKey][]models.StockLevel{}
+    for _, level := range locked {
+        key := stockKey{ProductID: level.ProductID}
+        if level.VariantID != nil {
+            key.VariantID = *level.VariantID
+        }
+        levels[key] = append(levels[key], level)
+    }
+    return warehouses, levels, nil
+}
+
+// levelsTotal sums the quantity held across stock levels
+func levelsTotal(levels []models.StockLevel) int {
+    total := 0
+    for _, level := range levels {
+        total += level.Quantity
+    }
+    return total
+}
+
+// takeFromLevels deducts an allocation from the in-memory levels, so a later
+// line for the same item sees what is left
+func takeFromLevels(levels []models.StockLevel, a allocation) {
+    for i := range levels {
+        if levels[i].WarehouseID == a.WarehouseID {
+            levels[i].Quantity -= a.Quantity
+            return
+        }
+    }
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[uint]int) []uint {
+    keys := make([]uint, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+    return keys
 }
 
-// GetOrders retrieves a list of a user's orders
+// GetOrders retrieves the logged-in user's orders
 func GetOrders(c *fiber.Ctx) error {
-    userIdStr := c.Query("user_id")
-    userId, err := strconv.Atoi(userIdStr)
+    user, ok := middleware.CurrentUser(c)
+    if !ok {
+        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+    }
+
+    return ordersForUser(c, user.UserID)
+}
+
+// GetUserOrders lets an admin retrieve any user's orders
+func GetUserOrders(c *fiber.Ctx) error {
+    userId, err := strconv.Atoi(c.Params("id"))
     if err != nil || userId < 1 {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
     }
 
+    return ordersForUser(c, uint(userId))
+}
+
+// ordersForUser sends one page of a user's orders, newest first
+func ordersForUser(c *fiber.Ctx, userID uint) error {
+    page := parsePageParams(c)
+    query := database.DB.Model(&models.Order{}).Where("user_id = ?", userID)
+
+    var total int64
+    if err := query.Count(&total).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get orders"})
+    }
+
     var orders []models.Order
-    if err := database.DB.Preload("Items").Where("user_id = ?", userId).Find(&orders).Error; err != nil {
+    if err := query.Preload("Items").Scopes(page.scope).Order("id DESC").Find(&orders).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get orders"})
     }
 
-    return c.JSON(orders)
+    return sendPage(c, orders, page, total)
 }
 
+// orderTransitions lists the statuses each order status may move to
+var orderTransitions = map[string][]string{
+    "pending": {"paid", "cancelled"},
+    "paid":    {"shipped", "cancelled"},
+    "shipped": {"delivered"},
+}
+
+var errOrderNotFound = errors.New("order not found")
+var errInvalidTransition = errors.New("invalid order status transition")
+
+// UpdateOrderStatus moves an order through its lifecycle. Cancelling an order
+// puts its items back into stock.
+func UpdateOrderStatus(c *fiber.Ctx) error {
+    orderID, err := strconv.Atoi(c.Params("id"))
+    if err != nil || orderID < 1 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid order ID"})
+    }
 
+    var input struct {
+        Status string `json:"status"`
+    }
+    if err := c.BodyParser(&input); err != nil || input.Status == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Status is required"})
+    }
+
+    var order models.Order
+    err = database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return errOrderNotFound
+            }
+            return err
+        }
+
+        allowed := false
+        for _, next := range orderTransitions[order.Status] {
+            if next == input.Status {
+                allowed = true
+                break
+            }
+        }
+        if !allowed {
+            return errInvalidTransition
+        }
+
+        if input.Status == "cancelled" {
+            // Archived products are restocked too, ready for a restore;
+            // variants deleted since the sale have no stock to return to
+            actor := requestActor(c)
+            reason := fmt.Sprintf("order #%d cancelled", order.Id)
+            for _, item := range order.Items {
+                restock := models.InventoryMovement{ProductID: item.ProductId, VariantID: item.VariantId, WarehouseID: item.WarehouseId, Type: models.MovementReturn, Quantity: item.Quantity, Reason: reason, OrderID: &order.Id}
+                if err := moveStock(tx, &restock, actor); err != nil && !errors.Is(err, errStockItemNotFound) {
+                    return err
+                }
+            }
+        }
+
+        order.Status = input.Status
+        return tx.Model(&order).Update("status", order.Status).Error
+    })
+
+    switch {
+    case err == nil:
+        return c.JSON(order)
+    case errors.Is(err, errOrderNotFound):
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+    case errors.Is(err, errInvalidTransition):
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Cannot move order from " + order.Status + " to " + input.Status})
+    default:
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update order"})
+    }
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestCheckoutDoesNotOversell(t *testing.T) {
+	requireDB(t)
+
+	const stock, shoppers = 3, 10
+	product := createTestProduct(t, stock)
+
+	app := fiber.New()
+	app.Post("/orders", middleware.JWTProtected(), CreateOrder)
+
+	tokens := make([]string, shoppers)
+	for i := range tokens {
+		user, token := createTestUser(t, fmt.Sprintf("shopper%d@example.com", i), "user")
+		addToTestCart(t, user.ID, product.ProductId, 1)
+		tokens[i] = token
+	}
+
+	statuses := make([]int, shoppers)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			statuses[i], _ = doRequest(t, app, http.MethodPost, "/orders", token, nil)
+		}(i, token)
+	}
+	wg.Wait()
+
+	created, conflicts := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case fiber.StatusCreated:
+			created++
+		case fiber.StatusConflict:
+			conflicts++
+		default:
+			t.Errorf("unexpected checkout status %d", status)
+		}
+	}
+	if created != stock || conflicts != shoppers-stock {
+		t.Errorf("got %d orders and %d conflicts, want %d and %d", created, conflicts, stock, shoppers-stock)
+	}
+
+	var reloaded models.Product
+	if err := database.DB.First(&reloaded, product.ProductId).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Stock != 0 {
+		t.Errorf("stock after checkouts = %d, want 0", reloaded.Stock)
+	}
+	var held int64
+	if err := database.DB.Model(&models.StockLevel{}).Where("product_id = ?", product.ProductId).Select("COALESCE(SUM(quantity), 0)").Scan(&held).Error; err != nil {
+		t.Fatal(err)
+	}
+	if held != 0 {
+		t.Errorf("warehouse stock after checkouts = %d, want 0", held)
+	}
+}
+
+func TestOrdersAreScopedToTheirUser(t *testing.T) {
+	requireDB(t)
+
+	alice, aliceToken := createTestUser(t, "alice@example.com", "user")
+	_, bobToken := createTestUser(t, "bob@example.com", "user")
+	_, agentToken := createTestUser(t, "agent@example.com", "support_agent")
+
+	order := models.Order{UserId: alice.ID, Total: 10, Status: "pending", Items: []models.OrderItem{{ProductId: 1, Quantity: 1, Price: 10}}}
+	if err := database.DB.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	product := createTestProduct(t, 5)
+	addToTestCart(t, alice.ID, product.ProductId, 1)
+
+	app := fiber.New()
+	app.Post("/orders", middleware.JWTProtected(), CreateOrder)
+	app.Get("/orders", middleware.JWTProtected(), GetOrders)
+	app.Get("/admin/users/:id/orders", middleware.JWTProtected(), middleware.Require("orders:read"), GetUserOrders)
+
+	listOrders := func(token, path string) []models.Order {
+		t.Helper()
+		status, body := doRequest(t, app, http.MethodGet, path, token, nil)
+		if status != fiber.StatusOK {
+			t.Fatalf("GET %s returned %d: %s", path, status, body)
+		}
+		var page struct {
+			Data []models.Order `json:"data"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Data
+	}
+
+	if orders := listOrders(aliceToken, "/orders"); len(orders) != 1 || orders[0].UserId != alice.ID {
+		t.Errorf("alice sees %+v, want her one order", orders)
+	}
+	if orders := listOrders(bobToken, "/orders"); len(orders) != 0 {
+		t.Errorf("bob sees %+v, want no orders", orders)
+	}
+
+	adminPath := fmt.Sprintf("/admin/users/%d/orders", alice.ID)
+	if status, _ := doRequest(t, app, http.MethodGet, adminPath, bobToken, nil); status != fiber.StatusForbidden {
+		t.Errorf("bob listing alice's orders got %d, want 403", status)
+	}
+	if orders := listOrders(agentToken, adminPath); len(orders) != 1 || orders[0].UserId != alice.ID {
+		t.Errorf("support agent sees %+v, want alice's one order", orders)
+	}
+
+	// Checkout only ever uses the caller's own cart
+	if status, _ := doRequest(t, app, http.MethodPost, "/orders", bobToken, nil); status != fiber.StatusNotFound {
+		t.Errorf("bob checking out without a cart got %d, want 404", status)
+	}
+	var items int64
+	database.DB.Model(&models.CartItem{}).Joins("JOIN carts ON carts.id = cart_items.cart_id").Where("carts.user_id = ?", alice.ID).Count(&items)
+	if items != 1 {
+		t.Errorf("alice's cart holds %d items after bob's checkout, want 1", items)
+	}
+	var orders int64
+	database.DB.Model(&models.Order{}).Where("user_id = ?", alice.ID).Count(&orders)
+	if orders != 1 {
+		t.Errorf("alice has %d orders after bob's checkout, want 1", orders)
+	}
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageParams holds the page/limit query parameters of a list request
+type pageParams struct {
+	Page  int
+	Limit int
+}
+
+// pageMeta is the pagination block of a list response envelope
+type pageMeta struct {
+	Page       int   `json:"page"`
+	Limit      int   `json:"limit"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// parsePageParams reads ?page= and ?limit=, falling back to sane defaults
+func parsePageParams(c *fiber.Ctx) pageParams {
+	p := pageParams{Page: c.QueryInt("page", 1), Limit: c.QueryInt("limit", defaultPageSize)}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit < 1 {
+		p.Limit = defaultPageSize
+	}
+	if p.Limit > maxPageSize {
+		p.Limit = maxPageSize
+	}
+	return p
+}
+
+// scope limits a query to the requested page
+func (p pageParams) scope(db *gorm.DB) *gorm.DB {
+	return db.Offset((p.Page - 1) * p.Limit).Limit(p.Limit)
+}
+
+// sendPage writes data wrapped in the standard {"data", "meta"} list envelope
+// and sets RFC 8288 Link headers for the neighbouring pages
+func sendPage(c *fiber.Ctx, data interface{}, p pageParams, total int64) error {
+	totalPages := int((total + int64(p.Limit) - 1) / int64(p.Limit))
+
+	var links []string
+	if p.Page > 1 {
+		links = append(links, pageLink(c, 1, "first"), pageLink(c, p.Page-1, "prev"))
+	}
+	if p.Page < totalPages {
+		links = append(links, pageLink(c, p.Page+1, "next"), pageLink(c, totalPages, "last"))
+	}
+	if len(links) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	}
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	return c.JSON(fiber.Map{
+		"data": data,
+		"meta": pageMeta{Page: p.Page, Limit: p.Limit, Total: total, TotalPages: totalPages},
+	})
+}
+
+// pageLink renders one Link header entry pointing at page, keeping the other query parameters
+func pageLink(c *fiber.Ctx, page int, rel string) string {
+	u, _ := url.Parse(c.OriginalURL())
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", u.RequestURI(), rel)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// maxPasswordResetsPerHour caps the links mailed to one account, so the
+	// endpoint cannot be used to flood an inbox from many addresses
+	maxPasswordResetsPerHour = 3
+)
+
+var errInvalidResetToken = errors.New("invalid password reset token")
+
+// ForgotPassword emails a reset link. The response is identical, and sent
+// before any lookup, whether or not the email is registered, so neither its
+// body nor its timing can be used to probe for accounts. Requests are limited
+// per client IP by the route and per account by sendPasswordReset.
+func ForgotPassword(c *fiber.Ctx) error {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&input); err != nil || strings.TrimSpace(input.Email) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Email is required"})
+	}
+
+	go sendPasswordReset(strings.ToLower(strings.TrimSpace(input.Email)))
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "If that email is registered, a reset link has been sent"})
+}
+
+// sendPasswordReset issues a reset link for the account with email, if there
+// is one, and mails it. It runs after the response is sent, so failures are
+// only logged.
+func sendPasswordReset(email string) {
+	var user models.User
+	if err := database.DB.Where("lower(email) = ?", email).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("failed to look up password reset for %s: %v", email, err)
+		}
+		return
+	}
+
+	var recent int64
+	if err := database.DB.Model(&models.PasswordReset{}).
+		Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-time.Hour)).
+		Count(&recent).Error; err != nil {
+		log.Printf("failed to start password reset for user %d: %v", user.ID, err)
+		return
+	}
+	if recent >= maxPasswordResetsPerHour {
+		log.Printf("password reset for user %d skipped: %d links sent in the last hour", user.ID, recent)
+		return
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		log.Printf("failed to start password reset for user %d: %v", user.ID, err)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the most recent link stays valid
+		if err := tx.Model(&models.PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		}).Error
+	})
+	if err != nil {
+		log.Printf("failed to start password reset for user %d: %v", user.ID, err)
+		return
+	}
+
+	sendMail(user.Email, "Reset your GO-MART password",
+		"Use this link to choose a new password. It expires in one hour.\n\n"+appLink("/reset-password", token))
+}
+
+// ResetPassword sets a new password using a reset token and signs the user out everywhere
+func ResetPassword(c *fiber.Ctx) error {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token is required"})
+	}
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hash, err := hashPassword(input.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var reset models.PasswordReset
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(input.Token), time.Now()).
+			First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&reset).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", hash).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, reset.UserID)
+	})
+
+	if errors.Is(err, errInvalidResetToken) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired token"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Password has been reset"})
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestPasswordResetsAreCappedPerAccount(t *testing.T) {
+	requireDB(t)
+
+	user, _ := createTestUser(t, "victim@example.com", "user")
+	for i := 0; i < maxPasswordResetsPerHour+2; i++ {
+		sendPasswordReset("victim@example.com")
+	}
+
+	var issued int64
+	if err := database.DB.Model(&models.PasswordReset{}).Where("user_id = ?", user.ID).Count(&issued).Error; err != nil {
+		t.Fatal(err)
+	}
+	if issued != maxPasswordResetsPerHour {
+		t.Errorf("%d reset links issued, want %d", issued, maxPasswordResetsPerHour)
+	}
+}
@@ -1,24 +1,100 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/pranavpatil6/go_mart/database"
 	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 
+// handlePattern is the shape of a product handle: a SKU-like code
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+const invalidHandleMessage = "Handle must be 1-64 letters, digits, '.', '_' or '-'"
+
+// productSellableSQL is the stock of a product row that checkout can sell:
+// what active warehouses hold of it, or of its variants when it has any
+const productSellableSQL = `(SELECT coalesce(sum(stock_levels.quantity), 0) FROM stock_levels
+    JOIN warehouses ON warehouses.id = stock_levels.warehouse_id AND warehouses.active
+    WHERE stock_levels.product_id = products.product_id
+    AND (stock_levels.variant_id IS NOT NULL) = EXISTS (SELECT 1 FROM variants WHERE variants.product_id = products.product_id))`
+
+// productSorts maps the ?sort= values to ORDER BY clauses
+var productSorts = map[string]string{
+    "price":  "price ASC, product_id ASC",
+    "-price": "price DESC, product_id ASC",
+    "title":  "title ASC, product_id ASC",
+    "-title": "title DESC, product_id ASC",
+    "newest": "product_id DESC",
+    "oldest": "product_id ASC",
+}
+
+// GetAllProducts lists products page by page. Supports ?min_price=, ?max_price=,
+// ?in_stock=true (stock in active warehouses), ?category= (ID or slug,
+// subcategories included) and ?sort= (price, -price, title, -title, newest, oldest).
 func GetAllProducts(c *fiber.Ctx) error {
+    page := parsePageParams(c)
+
+    query := database.DB.Model(&models.Product{})
+    if v := c.Query("min_price"); v != "" {
+        minPrice, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid min_price"})
+        }
+        query = query.Where("price >= ?", minPrice)
+    }
+    if v := c.Query("max_price"); v != "" {
+        maxPrice, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid max_price"})
+        }
+        query = query.Where("price <= ?", maxPrice)
+    }
+    if c.QueryBool("in_stock") {
+        query = query.Where(productSellableSQL + " > 0")
+    }
+    if ref := c.Query("category"); ref != "" {
+        category, err := findCategory(ref)
+        if err != nil {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+        }
+        ids, err := categoryDescendantIDs(database.DB, category.ID)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+        }
+        query = query.Where("product_id IN (?)", database.DB.Table("product_categories").Select("product_id").Where("category_id IN ?", ids))
+    }
+
+    order, ok := productSorts[c.Query("sort", "newest")]
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid sort"})
+    }
+
+    var total int64
+    if err := query.Count(&total).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+    }
+
     var products []models.Product
-    result := database.DB.Find(&products)
-    if result.Error != nil {
+    if err := query.Preload("Images", orderedImages).Scopes(page.scope).Order(order).Find(&products).Error; err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
             "error": "Failed to retrieve products",
         })
     }
+    if err := withVariantStock(database.DB, products); err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+    }
+    withProductImageURLs(products)
 
-    return c.JSON(products)
+    return sendPage(c, products, page, total)
 }
 
 func GetProduct(c *fiber.Ctx) error {
@@ -29,11 +105,19 @@ func GetProduct(c *fiber.Ctx) error {
     }
 
     var product models.Product
-    result := database.DB.First(&product, id)
+    result := database.DB.Preload("Categories").Preload("Variants").Preload("Images", orderedImages).First(&product, id)
     if result.Error != nil {
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
     }
+    withImageURLs(product.Images)
+    if len(product.Variants) > 0 {
+        product.Stock = 0
+        for _, v := range product.Variants {
+            product.Stock += v.Stock
+        }
+    }
 
+    c.Set(fiber.HeaderETag, productETag(product))
     return c.JSON(product)
 }
 
@@ -44,14 +128,67 @@ func CreateProduct(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
     }
 
-    result := database.DB.Create(&product)
-    if result.Error != nil {
+    product.Handle = strings.TrimSpace(product.Handle)
+    if product.Handle != "" && !handlePattern.MatchString(product.Handle) {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": invalidHandleMessage})
+    }
+
+    product.Version = 1
+    if product.Stock < 0 {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Stock cannot be negative"})
+    }
+    if product.ReorderThreshold < 0 {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Reorder threshold cannot be negative"})
+    }
+
+    // Categories are assigned through their own endpoint, and the initial
+    // stock opens the product's inventory ledger
+    stock := product.Stock
+    product.Stock = 0
+    err := database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Omit(clause.Associations).Create(&product).Error; err != nil {
+            return err
+        }
+        if product.Handle == "" {
+            product.Handle = defaultHandle(product)
+            if err := tx.Model(&product).Update("handle", product.Handle).Error; err != nil {
+                return err
+            }
+        }
+        opening := models.InventoryMovement{ProductID: product.ProductId, Type: models.MovementReceipt, Quantity: stock, Reason: "initial stock"}
+        if err := moveStock(tx, &opening, requestActor(c)); err != nil {
+            return err
+        }
+        product.Stock = stock
+        return nil
+    })
+    if errors.Is(err, gorm.ErrDuplicatedKey) {
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Handle already in use"})
+    }
+    if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create product"})
     }
 
+    c.Set(fiber.HeaderETag, productETag(product))
     return c.Status(fiber.StatusCreated).JSON(product)
 }
 
+// defaultHandle derives a handle for a product created without one. The ID
+// suffix keeps it unique between products with the same title.
+func defaultHandle(product models.Product) string {
+    base := slugify(product.Title)
+    if len(base) > 40 {
+        base = strings.Trim(base[:40], "-")
+    }
+    if base == "" {
+        base = "product"
+    }
+    return fmt.Sprintf("%s-%d", base, product.ProductId)
+}
+
+// UpdateProduct replaces a product's title, description, price and stock,
+// which must all be present: a missing one is not taken to mean zero. Use
+// PatchProduct to change only some of them.
 func UpdateProduct(c *fiber.Ctx) error {
     idParam := c.Params("id")
     id, err := strconv.Atoi(idParam)
@@ -59,30 +196,223 @@ func UpdateProduct(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
     }
 
-    var product models.Product
-    result := database.DB.First(&product, id)
-    if result.Error != nil {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+    var body productPatch
+    if err := c.BodyParser(&body); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+    }
+    if body.Title == nil || body.Description == nil || body.Price == nil || body.Stock == nil {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Title, description, price and stock are required; use PATCH to change only some of them"})
+    }
+
+    input := productPatch{
+        Title:       body.Title,
+        Description: body.Description,
+        Price:       body.Price,
+        Stock:       body.Stock,
+    }
+    return updateProduct(c, id, input)
+}
+
+// productPatch is the body of PATCH /products/:id. Absent fields are left unchanged.
+type productPatch struct {
+    Handle           *string  `json:"handle"`
+    Title            *string  `json:"title"`
+    Description      *string  `json:"description"`
+    Price            *float64 `json:"price"`
+    Stock            *int     `json:"stock"`
+    ReorderThreshold *int     `json:"reorder_threshold"`
+}
+
+var errProductNotFound = errors.New("product not found")
+var errProductModified = errors.New("product was modified")
+var errProductHasVariants = errors.New("product stock is held by its variants")
+
+// PatchProduct changes only the fields present in the body. Send the ETag
+// from GetProduct as If-Match to make sure nobody else edited it meanwhile.
+func PatchProduct(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
     }
 
-    var updateData models.Product
-    if err := c.BodyParser(&updateData); err != nil {
+    var input productPatch
+    if err := c.BodyParser(&input); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
     }
+    return updateProduct(c, id, input)
+}
+
+// updateProduct validates and applies input to product id under a row lock,
+// bumping its version. The If-Match header, when sent, must hold the
+// current ETag.
+func updateProduct(c *fiber.Ctx, id int, input productPatch) error {
+    updates, msg := productPatchUpdates(input)
+    if msg != "" {
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+    }
+
+    var product models.Product
+    err := database.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return errProductNotFound
+            }
+            return err
+        }
+        if !etagMatches(c.Get(fiber.HeaderIfMatch), productETag(product)) {
+            return errProductModified
+        }
+        var variants []models.Variant
+        if err := tx.Where("product_id = ?", id).Find(&variants).Error; err != nil {
+            return err
+        }
+        if len(variants) > 0 {
+            product.Stock = 0
+            for _, v := range variants {
+                product.Stock += v.Stock
+            }
+        }
+        variantStock := product.Stock
 
-    product.Title = updateData.Title
-    product.Description = updateData.Description
-    product.Price = updateData.Price
-    product.Stock = updateData.Stock
+        // An edit that changes nothing leaves the version, and so the ETag, as it is
+        dropUnchangedFields(product, updates)
+        if len(updates) == 0 {
+            return nil
+        }
+        // The stock of a product sold in variants is changed per variant
+        if _, ok := updates["stock"]; ok && len(variants) > 0 {
+            return errProductHasVariants
+        }
 
-    saveResult := database.DB.Save(&product)
-    if saveResult.Error != nil {
+        // Stock changes are recorded as ledger adjustments
+        if stock, ok := updates["stock"].(int); ok {
+            delete(updates, "stock")
+            adjustment := models.InventoryMovement{
+                ProductID: product.ProductId,
+                Type:      models.MovementAdjustment,
+                Quantity:  stock - product.Stock,
+                Reason:    "stock set by product edit",
+            }
+            if err := moveStock(tx, &adjustment, requestActor(c)); err != nil {
+                return err
+            }
+        }
+
+        updates["version"] = product.Version + 1
+        if err := tx.Model(&product).Updates(updates).Error; err != nil {
+            return err
+        }
+        if err := tx.First(&product, id).Error; err != nil {
+            return err
+        }
+        if len(variants) > 0 {
+            product.Stock = variantStock
+        }
+        return nil
+    })
+
+    switch {
+    case err == nil:
+        c.Set(fiber.HeaderETag, productETag(product))
+        return c.JSON(product)
+    case errors.Is(err, errProductNotFound):
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+    case errors.Is(err, errProductModified):
+        c.Set(fiber.HeaderETag, productETag(product))
+        return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": "Product was changed by someone else; reload it and retry"})
+    case errors.Is(err, gorm.ErrDuplicatedKey):
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Handle already in use"})
+    case errors.Is(err, errProductHasVariants):
+        return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Choose a variant of this product"})
+    case errors.Is(err, errNegativeStock):
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Stock reduction exceeds what the default warehouse holds"})
+    default:
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update product"})
     }
+}
 
-    return c.JSON(product)
+// productPatchUpdates validates input and turns it into column updates,
+// returning a validation message when it is not acceptable
+func productPatchUpdates(input productPatch) (map[string]interface{}, string) {
+    updates := map[string]interface{}{}
+    if input.Handle != nil {
+        handle := strings.TrimSpace(*input.Handle)
+        if !handlePattern.MatchString(handle) {
+            return nil, invalidHandleMessage
+        }
+        updates["handle"] = handle
+    }
+    if input.Title != nil {
+        title := strings.TrimSpace(*input.Title)
+        if title == "" {
+            return nil, "Title cannot be empty"
+        }
+        updates["title"] = title
+    }
+    if input.Description != nil {
+        updates["description"] = *input.Description
+    }
+    if input.Price != nil {
+        if *input.Price < 0 {
+            return nil, "Price cannot be negative"
+        }
+        updates["price"] = *input.Price
+    }
+    if input.Stock != nil {
+        if *input.Stock < 0 {
+            return nil, "Stock cannot be negative"
+        }
+        updates["stock"] = *input.Stock
+    }
+    if input.ReorderThreshold != nil {
+        if *input.ReorderThreshold < 0 {
+            return nil, "Reorder threshold cannot be negative"
+        }
+        updates["reorder_threshold"] = *input.ReorderThreshold
+    }
+    return updates, ""
+}
+
+// dropUnchangedFields removes the updates that would set a column to the
+// value it already holds
+func dropUnchangedFields(product models.Product, updates map[string]interface{}) {
+    current := map[string]interface{}{
+        "handle":            product.Handle,
+        "title":             product.Title,
+        "description":       product.Description,
+        "price":             product.Price,
+        "stock":             product.Stock,
+        "reorder_threshold": product.ReorderThreshold,
+    }
+    for column, value := range updates {
+        if current[column] == value {
+            delete(updates, column)
+        }
+    }
+}
+
+// productETag is the entity tag of a product's current version
+func productETag(product models.Product) string {
+    return fmt.Sprintf(`"%d"`, product.Version)
 }
 
+// etagMatches implements If-Match: an absent header or * matches anything,
+// otherwise one of the listed tags must equal etag
+func etagMatches(header, etag string) bool {
+    if header == "" {
+        return true
+    }
+    for _, tag := range strings.Split(header, ",") {
+        tag = strings.TrimSpace(tag)
+        if tag == "*" || tag == etag {
+            return true
+        }
+    }
+    return false
+}
+
+// DeleteProduct archives a product. It disappears from the catalog and can no
+// longer be added to carts, while carts and orders keep referencing it.
 func DeleteProduct(c *fiber.Ctx) error {
     idParam := c.Params("id")
     id, err := strconv.Atoi(idParam)
@@ -103,3 +433,40 @@ func DeleteProduct(c *fiber.Ctx) error {
 
     return c.Status(fiber.StatusNoContent).JSON(fiber.Map{"message": "Product Deleted"})
 }
+
+// GetArchivedProducts lists archived products, most recently archived first
+func GetArchivedProducts(c *fiber.Ctx) error {
+    page := parsePageParams(c)
+    query := database.DB.Unscoped().Model(&models.Product{}).Where("archived_at IS NOT NULL")
+
+    var total int64
+    if err := query.Count(&total).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+    }
+
+    var products []models.Product
+    if err := query.Scopes(page.scope).Order("archived_at DESC, product_id").Find(&products).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve products"})
+    }
+
+    return sendPage(c, products, page, total)
+}
+
+// RestoreProduct puts an archived product back in the catalog
+func RestoreProduct(c *fiber.Ctx) error {
+    id, err := strconv.Atoi(c.Params("id"))
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+    }
+
+    var product models.Product
+    if err := database.DB.Unscoped().Where("archived_at IS NOT NULL").First(&product, id).Error; err != nil {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Archived product not found"})
+    }
+
+    if err := database.DB.Unscoped().Model(&product).Update("archived_at", nil).Error; err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to restore product"})
+    }
+    product.ArchivedAt = gorm.DeletedAt{}
+    return c.JSON(product)
+}
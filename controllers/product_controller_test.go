@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// createTestVariant adds a variant to product with stock received into the
+// default warehouse
+func createTestVariant(t *testing.T, product models.Product, sku string, stock int) models.Variant {
+	t.Helper()
+	variant := models.Variant{ProductID: product.ProductId, SKU: sku}
+	if err := database.DB.Create(&variant).Error; err != nil {
+		t.Fatalf("failed to create variant: %v", err)
+	}
+	receipt := models.InventoryMovement{ProductID: product.ProductId, VariantID: &variant.ID, Type: models.MovementReceipt, Quantity: stock, Reason: "test stock"}
+	if err := moveStock(database.DB, &receipt, systemActor); err != nil {
+		t.Fatalf("failed to stock variant: %v", err)
+	}
+	variant.Stock = stock
+	return variant
+}
+
+// listInStock returns the stock of each product GET /products?in_stock=true lists
+func listInStock(t *testing.T) map[uint]int {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/products", GetAllProducts)
+	status, body := doRequest(t, app, http.MethodGet, "/products?in_stock=true", "", nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("GET /products returned %d: %s", status, body)
+	}
+	var page struct {
+		Data []models.Product `json:"data"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	stock := map[uint]int{}
+	for _, p := range page.Data {
+		stock[p.ProductId] = p.Stock
+	}
+	return stock
+}
+
+func TestInStockListingCountsVariants(t *testing.T) {
+	requireDB(t)
+
+	plain := createTestProduct(t, 2)
+	soldOut := createTestProduct(t, 0)
+	sized := createTestProduct(t, 0)
+	createTestVariant(t, sized, "TEE-S", 0)
+	createTestVariant(t, sized, "TEE-L", 4)
+	emptySized := createTestProduct(t, 0)
+	createTestVariant(t, emptySized, "CAP-S", 0)
+
+	listed := listInStock(t)
+	if listed[plain.ProductId] != 2 || listed[sized.ProductId] != 4 {
+		t.Errorf("in-stock listing = %v, want product %d with 2 and product %d with 4", listed, plain.ProductId, sized.ProductId)
+	}
+	for _, id := range []uint{soldOut.ProductId, emptySized.ProductId} {
+		if _, ok := listed[id]; ok {
+			t.Errorf("out-of-stock product %d is listed as in stock", id)
+		}
+	}
+}
+
+func TestDropUnchangedFields(t *testing.T) {
+	product := models.Product{Handle: "LAMP-1", Title: "Lamp", Description: "Brass", Price: 10, Stock: 3, ReorderThreshold: 1}
+	updates := map[string]interface{}{
+		"handle":            "LAMP-1",
+		"title":             "Desk lamp",
+		"description":       "Brass",
+		"price":             10.0,
+		"stock":             4,
+		"reorder_threshold": 1,
+	}
+	dropUnchangedFields(product, updates)
+	if len(updates) != 2 || updates["title"] != "Desk lamp" || updates["stock"] != 4 {
+		t.Errorf("updates left = %v, want only title and stock", updates)
+	}
+}
+
+func TestUpdateProductKeepsOmittedStock(t *testing.T) {
+	requireDB(t)
+
+	product := createTestProduct(t, 5)
+	app := fiber.New()
+	app.Put("/products/:id", UpdateProduct)
+	path := fmt.Sprintf("/products/%d", product.ProductId)
+
+	body := fiber.Map{"title": "Renamed", "description": "", "price": 12}
+	if status, _ := doRequest(t, app, http.MethodPut, path, "", body); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("PUT without stock got %d, want 422", status)
+	}
+
+	body["title"], body["price"], body["stock"] = product.Title, product.Price, product.Stock
+	if status, resp := doRequest(t, app, http.MethodPut, path, "", body); status != fiber.StatusOK {
+		t.Fatalf("PUT with the current values got %d: %s", status, resp)
+	}
+
+	var reloaded models.Product
+	database.DB.First(&reloaded, product.ProductId)
+	if reloaded.Stock != 5 || reloaded.Version != 1 {
+		t.Errorf("after the PUTs stock = %d, version = %d; want 5 and 1", reloaded.Stock, reloaded.Version)
+	}
+	var movements int64
+	database.DB.Model(&models.InventoryMovement{}).Where("product_id = ?", product.ProductId).Count(&movements)
+	if movements != 1 {
+		t.Errorf("%d ledger movements, want only the opening receipt", movements)
+	}
+}
+
+func TestProductStockEditsNeedAVariant(t *testing.T) {
+	requireDB(t)
+
+	product := createTestProduct(t, 0)
+	createTestVariant(t, product, "TEE-S", 2)
+	createTestVariant(t, product, "TEE-L", 3)
+
+	app := fiber.New()
+	app.Patch("/products/:id", PatchProduct)
+	path := fmt.Sprintf("/products/%d", product.ProductId)
+
+	if status, _ := doRequest(t, app, http.MethodPatch, path, "", fiber.Map{"stock": 9}); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("PATCH of a variant product's stock got %d, want 422", status)
+	}
+	// Resending the variants' total is not a stock change
+	if status, body := doRequest(t, app, http.MethodPatch, path, "", fiber.Map{"title": "Tee", "stock": 5}); status != fiber.StatusOK {
+		t.Errorf("PATCH with the current stock got %d: %s", status, body)
+	}
+
+	var movements int64
+	database.DB.Model(&models.InventoryMovement{}).Where("product_id = ? AND variant_id IS NULL", product.ProductId).Count(&movements)
+	if movements != 0 {
+		t.Errorf("%d product-level movements for a product sold in variants", movements)
+	}
+}
@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/storage"
+	"github.com/pranavpatil6/go_mart/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxImageBytes = 5 << 20
+	// maxImagePixels guards against decompression bombs: small files that
+	// declare huge dimensions
+	maxImagePixels = 40_000_000
+	// maxUploadImages and maxUploadPixels bound the work one request can ask for
+	maxUploadImages = 10
+	maxUploadPixels = 100_000_000
+	thumbnailSize   = 320
+)
+
+// imageExtensions lists the accepted image types, detected from file content
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+var errImageOrder = errors.New("image_ids must list every image of the product exactly once")
+var errImageUndecodable = errors.New("image could not be decoded")
+
+// orderedImages is a Preload scope returning a product's images in display order
+func orderedImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+// withImageURLs fills in the public URLs of each image
+func withImageURLs(images []models.ProductImage) {
+	for i := range images {
+		images[i].URL = storage.Store.URL(images[i].Key)
+		images[i].ThumbnailURL = storage.Store.URL(images[i].ThumbnailKey)
+	}
+}
+
+// withProductImageURLs fills in the image URLs of every product
+func withProductImageURLs(products []models.Product) {
+	for i := range products {
+		withImageURLs(products[i].Images)
+	}
+}
+
+// UploadProductImages stores the images sent as multipart "images" (or
+// "image") fields and appends them to the product's gallery
+func UploadProductImages(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var product models.Product
+	if err := database.DB.First(&product, productID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected a multipart form"})
+	}
+	files := append(form.File["images"], form.File["image"]...)
+	if len(files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No image files uploaded"})
+	}
+	if len(files) > maxUploadImages {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": fmt.Sprintf("Upload at most %d images at a time", maxUploadImages)})
+	}
+
+	// Validate everything before storing anything. Only headers are read
+	// here; each image is decoded, stored and released in turn below.
+	uploads := make([]imageUpload, 0, len(files))
+	pixels := 0
+	for _, fh := range files {
+		upload, msg := readImageUpload(fh)
+		if msg != "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg, "file": fh.Filename})
+		}
+		pixels += upload.width * upload.height
+		if pixels > maxUploadPixels {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Images are too large in total; upload fewer at a time"})
+		}
+		uploads = append(uploads, upload)
+	}
+
+	ctx := c.Context()
+	var images []models.ProductImage
+	var storedKeys []string
+	for _, upload := range uploads {
+		stored, err := storeImageUpload(ctx, product.ProductId, upload)
+		if errors.Is(err, errImageUndecodable) {
+			deleteBlobs(ctx, storedKeys...)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Image could not be decoded", "file": upload.filename})
+		}
+		if err != nil {
+			log.Printf("storage: %v", err)
+			deleteBlobs(ctx, storedKeys...)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to store image"})
+		}
+		storedKeys = append(storedKeys, stored.Key, stored.ThumbnailKey)
+		images = append(images, stored)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Locking the product serializes position assignment between uploads
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Product{}, product.ProductId).Error; err != nil {
+			return err
+		}
+		var next int64
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ProductId).Count(&next).Error; err != nil {
+			return err
+		}
+		for i := range images {
+			images[i].Position = int(next) + i
+		}
+		return tx.Create(&images).Error
+	})
+	if err != nil {
+		deleteBlobs(ctx, storedKeys...)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save images"})
+	}
+
+	withImageURLs(images)
+	return c.Status(fiber.StatusCreated).JSON(images)
+}
+
+// ReorderProductImages sets the display order from a full list of image IDs
+func ReorderProductImages(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	var input struct {
+		ImageIDs []uint `json:"image_ids"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var images []models.ProductImage
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", productID).Find(&images).Error; err != nil {
+			return err
+		}
+		positions := map[uint]int{}
+		for i, id := range input.ImageIDs {
+			positions[id] = i
+		}
+		if len(positions) != len(input.ImageIDs) || len(positions) != len(images) {
+			return errImageOrder
+		}
+		for i := range images {
+			position, ok := positions[images[i].ID]
+			if !ok {
+				return errImageOrder
+			}
+			images[i].Position = position
+			if err := tx.Model(&images[i]).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errImageOrder) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reorder images"})
+	}
+
+	images = orderImages(images)
+	withImageURLs(images)
+	return c.JSON(images)
+}
+
+// DeleteProductImage removes an image and its stored files
+func DeleteProductImage(c *fiber.Ctx) error {
+	productID, err1 := strconv.Atoi(c.Params("id"))
+	imageID, err2 := strconv.Atoi(c.Params("imageId"))
+	if err1 != nil || err2 != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product or image ID"})
+	}
+
+	var img models.ProductImage
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND product_id = ?", imageID, productID).First(&img).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&img).Error; err != nil {
+			return err
+		}
+		// Close the gap left in the ordering
+		return tx.Model(&models.ProductImage{}).Where("product_id = ? AND position > ?", productID, img.Position).
+			Update("position", gorm.Expr("position - 1")).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Image not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete image"})
+	}
+
+	deleteBlobs(c.Context(), img.Key, img.ThumbnailKey)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// imageUpload is a validated upload waiting to be stored
+type imageUpload struct {
+	filename      string
+	data          []byte
+	contentType   string
+	width, height int
+}
+
+// readImageUpload reads and validates one uploaded file, returning a
+// validation message when it is not an acceptable image
+func readImageUpload(fh *multipart.FileHeader) (imageUpload, string) {
+	var upload imageUpload
+	if fh.Size > maxImageBytes {
+		return upload, fmt.Sprintf("Image exceeds the %d MB limit", maxImageBytes>>20)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return upload, "Could not read uploaded file"
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxImageBytes+1))
+	if err != nil {
+		return upload, "Could not read uploaded file"
+	}
+	if len(data) > maxImageBytes {
+		return upload, fmt.Sprintf("Image exceeds the %d MB limit", maxImageBytes>>20)
+	}
+
+	// Trust the bytes, not the client's Content-Type or file name
+	contentType := http.DetectContentType(data)
+	if _, ok := imageExtensions[contentType]; !ok {
+		return upload, "Only JPEG, PNG and GIF images are accepted"
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return upload, "Image could not be decoded"
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return upload, "Image dimensions are too large"
+	}
+
+	return imageUpload{filename: fh.Filename, data: data, contentType: contentType, width: cfg.Width, height: cfg.Height}, ""
+}
+
+// storeImageUpload decodes an upload and writes the original and a JPEG
+// thumbnail to the blob store
+func storeImageUpload(ctx context.Context, productID uint, upload imageUpload) (models.ProductImage, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(upload.data))
+	if err != nil {
+		return models.ProductImage{}, errImageUndecodable
+	}
+	name, err := utils.RandomToken(16)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+	img := models.ProductImage{
+		ProductID:    productID,
+		Key:          fmt.Sprintf("products/%d/%s.%s", productID, name, imageExtensions[upload.contentType]),
+		ThumbnailKey: fmt.Sprintf("products/%d/%s_thumb.jpg", productID, name),
+		ContentType:  upload.contentType,
+		Width:        decoded.Bounds().Dx(),
+		Height:       decoded.Bounds().Dy(),
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, utils.Thumbnail(decoded, thumbnailSize), &jpeg.Options{Quality: 85}); err != nil {
+		return img, err
+	}
+
+	if err := storage.Store.Put(ctx, img.Key, upload.data, upload.contentType); err != nil {
+		return img, err
+	}
+	if err := storage.Store.Put(ctx, img.ThumbnailKey, thumb.Bytes(), "image/jpeg"); err != nil {
+		deleteBlobs(ctx, img.Key)
+		return img, err
+	}
+	return img, nil
+}
+
+// deleteBlobs removes stored files, logging rather than failing on errors
+func deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := storage.Store.Delete(ctx, key); err != nil {
+			log.Printf("storage: %v", err)
+		}
+	}
+}
+
+// orderImages returns images sorted by position
+func orderImages(images []models.ProductImage) []models.ProductImage {
+	ordered := make([]models.ProductImage, len(images))
+	for _, img := range images {
+		ordered[img.Position] = img
+	}
+	return ordered
+}
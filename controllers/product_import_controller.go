@@ -0,0 +1,433 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	importBatchSize = 500
+	// maxImportLine bounds a single JSON Lines record
+	maxImportLine = 1 << 20
+)
+
+// productCSVColumns are the CSV export columns, and the columns an import understands
+var productCSVColumns = []string{"handle", "title", "description", "price", "stock"}
+
+// errImportRolledBack discards the import transaction after a dry run or
+// when any row is invalid
+var errImportRolledBack = errors.New("import rolled back")
+
+// productImportRow is one parsed import record. Nil fields were absent from
+// the record and are left unchanged on existing products.
+type productImportRow struct {
+	Line        int      `json:"-"`
+	Handle      string   `json:"handle"`
+	Title       *string  `json:"title"`
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price"`
+	Stock       *int     `json:"stock"`
+}
+
+// importRowError reports why a record was rejected
+type importRowError struct {
+	Line   int    `json:"line"`
+	Handle string `json:"handle,omitempty"`
+	Error  string `json:"error"`
+}
+
+// importReport summarizes an import run
+type importReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Applied bool             `json:"applied"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Errors  []importRowError `json:"errors"`
+}
+
+// ImportProducts upserts products by handle from a CSV or JSON Lines body.
+// The format comes from ?format= or the Content-Type. With ?dry_run=true
+// the file is only validated. Rows are written in batches inside a single
+// transaction, which is rolled back if any row is invalid.
+func ImportProducts(c *fiber.Ctx) error {
+	format := importFormat(c)
+
+	var rows []productImportRow
+	var rowErrors []importRowError
+	var err error
+	switch format {
+	case "csv":
+		rows, rowErrors, err = parseCSVImport(bytes.NewReader(c.Body()))
+	case "jsonl":
+		rows, rowErrors, err = parseJSONLImport(bytes.NewReader(c.Body()))
+	default:
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "Use format=csv or format=jsonl"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	report := importReport{DryRun: c.QueryBool("dry_run"), Rows: len(rows) + len(rowErrors), Errors: rowErrors}
+	actor := requestActor(c)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		seen := map[string]int{}
+		for start := 0; start < len(rows); start += importBatchSize {
+			batch := rows[start:min(start+importBatchSize, len(rows))]
+			if err := importBatch(tx, batch, seen, &report, actor); err != nil {
+				return err
+			}
+		}
+		if report.DryRun || len(report.Errors) > 0 {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		report.Applied = true
+	case errors.Is(err, errImportRolledBack):
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A product handle was created concurrently; retry the import"})
+	case errors.Is(err, errNegativeStock):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Stock reductions exceed what the default warehouse holds"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to import products"})
+	}
+
+	if len(report.Errors) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(report)
+	}
+	return c.JSON(report)
+}
+
+// importBatch validates and writes one batch of rows. Rows are still
+// validated after an error, so the report lists every problem in the file,
+// but nothing is kept once an error was reported.
+func importBatch(tx *gorm.DB, batch []productImportRow, seen map[string]int, report *importReport, actor stockActor) error {
+	handles := make([]string, len(batch))
+	for i, row := range batch {
+		handles[i] = row.Handle
+	}
+
+	// Archived products still own their handle and are updated in place
+	var existing []models.Product
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("handle IN ?", handles).Order("product_id").Find(&existing).Error; err != nil {
+		return err
+	}
+	// Stock is compared with what ExportProducts wrote
+	if err := withVariantStock(tx, existing); err != nil {
+		return err
+	}
+	byHandle := map[string]models.Product{}
+	ids := make([]uint, len(existing))
+	for i, p := range existing {
+		byHandle[p.Handle] = p
+		ids[i] = p.ProductId
+	}
+	var variantProductIDs []uint
+	if err := tx.Model(&models.Variant{}).Where("product_id IN ?", ids).Distinct().Pluck("product_id", &variantProductIDs).Error; err != nil {
+		return err
+	}
+	hasVariants := map[uint]bool{}
+	for _, id := range variantProductIDs {
+		hasVariants[id] = true
+	}
+
+	var created []models.Product
+	var createdStock []int
+	for _, row := range batch {
+		if first, ok := seen[row.Handle]; ok {
+			report.Errors = append(report.Errors, importRowError{Line: row.Line, Handle: row.Handle, Error: fmt.Sprintf("duplicate handle, first seen on line %d", first)})
+			continue
+		}
+		seen[row.Handle] = row.Line
+
+		product, exists := byHandle[row.Handle]
+		if msg := validateImportRow(row, exists); msg != "" {
+			report.Errors = append(report.Errors, importRowError{Line: row.Line, Handle: row.Handle, Error: msg})
+			continue
+		}
+
+		if !exists {
+			product = models.Product{Handle: row.Handle, Version: 1}
+		}
+		updates := map[string]interface{}{}
+		if row.Title != nil {
+			product.Title = strings.TrimSpace(*row.Title)
+			updates["title"] = product.Title
+		}
+		if row.Description != nil {
+			product.Description = *row.Description
+			updates["description"] = product.Description
+		}
+		if row.Price != nil {
+			product.Price = *row.Price
+			updates["price"] = product.Price
+		}
+
+		// Stock goes through the inventory ledger rather than the column
+		stockDelta := 0
+		if row.Stock != nil {
+			stockDelta = *row.Stock - product.Stock
+		}
+		if stockDelta != 0 && hasVariants[product.ProductId] {
+			report.Errors = append(report.Errors, importRowError{Line: row.Line, Handle: row.Handle, Error: "stock of a product with variants is set per variant"})
+			continue
+		}
+
+		if !exists {
+			report.Created++
+			created = append(created, product)
+			createdStock = append(createdStock, stockDelta)
+			continue
+		}
+		report.Updated++
+		if len(report.Errors) > 0 {
+			continue
+		}
+		if len(updates) > 0 || stockDelta != 0 {
+			updates["version"] = gorm.Expr("version + 1")
+			if err := tx.Unscoped().Model(&models.Product{}).Where("product_id = ?", product.ProductId).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		adjustment := models.InventoryMovement{ProductID: product.ProductId, Type: models.MovementAdjustment, Quantity: stockDelta, Reason: "bulk import"}
+		if err := moveStock(tx, &adjustment, actor); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Errors) > 0 || len(created) == 0 {
+		return nil
+	}
+	if err := tx.Omit(clause.Associations).Create(&created).Error; err != nil {
+		return err
+	}
+	for i, product := range created {
+		receipt := models.InventoryMovement{ProductID: product.ProductId, Type: models.MovementReceipt, Quantity: createdStock[i], Reason: "bulk import"}
+		if err := moveStock(tx, &receipt, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateImportRow returns why a row cannot be applied, or "" when it can
+func validateImportRow(row productImportRow, exists bool) string {
+	switch {
+	case row.Title != nil && strings.TrimSpace(*row.Title) == "":
+		return "title cannot be empty"
+	case row.Price != nil && *row.Price < 0:
+		return "price cannot be negative"
+	case row.Stock != nil && *row.Stock < 0:
+		return "stock cannot be negative"
+	case !exists && row.Title == nil:
+		return "title is required for new products"
+	case !exists && row.Price == nil:
+		return "price is required for new products"
+	}
+	return ""
+}
+
+// importFormat picks "csv" or "jsonl" from ?format= or the Content-Type
+func importFormat(c *fiber.Ctx) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return "jsonl"
+	}
+	return ""
+}
+
+// parseCSVImport reads a CSV file whose header row names the columns.
+// Columns missing from the header are left unchanged on existing products.
+func parseCSVImport(r io.Reader) ([]productImportRow, []importRowError, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("CSV header row is missing or malformed")
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		known := false
+		for _, col := range productCSVColumns {
+			known = known || col == name
+		}
+		if !known {
+			return nil, nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["handle"]; !ok {
+		return nil, nil, errors.New("CSV header must include a handle column")
+	}
+
+	var rows []productImportRow
+	var rowErrors []importRowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, importRowError{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := productImportRow{Line: line, Handle: strings.TrimSpace(record[columns["handle"]])}
+		var msg string
+		if !handlePattern.MatchString(row.Handle) {
+			msg = invalidHandleMessage
+		}
+		if i, ok := columns["title"]; ok {
+			row.Title = &record[i]
+		}
+		if i, ok := columns["description"]; ok {
+			row.Description = &record[i]
+		}
+		if i, ok := columns["price"]; ok {
+			price, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+			if err != nil && msg == "" {
+				msg = "price must be a number"
+			}
+			row.Price = &price
+		}
+		if i, ok := columns["stock"]; ok {
+			stock, err := strconv.Atoi(strings.TrimSpace(record[i]))
+			if err != nil && msg == "" {
+				msg = "stock must be a whole number"
+			}
+			row.Stock = &stock
+		}
+
+		if msg != "" {
+			rowErrors = append(rowErrors, importRowError{Line: line, Handle: row.Handle, Error: msg})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// parseJSONLImport reads one JSON object per line; blank lines are skipped
+func parseJSONLImport(r io.Reader) ([]productImportRow, []importRowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+
+	var rows []productImportRow
+	var rowErrors []importRowError
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var row productImportRow
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row); err != nil {
+			rowErrors = append(rowErrors, importRowError{Line: line, Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+		row.Line = line
+		row.Handle = strings.TrimSpace(row.Handle)
+		if !handlePattern.MatchString(row.Handle) {
+			rowErrors = append(rowErrors, importRowError{Line: line, Handle: row.Handle, Error: invalidHandleMessage})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("reading JSON Lines: %w", err)
+	}
+	return rows, rowErrors, nil
+}
+
+// ExportProducts streams the catalog as CSV or JSON Lines (?format=csv|jsonl),
+// in the same shape ImportProducts accepts. Archived products are left out,
+// and products sold in variants list the total of their variants as stock.
+func ExportProducts(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	switch format {
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	case "jsonl":
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Use format=csv or format=jsonl"})
+	}
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="products.`+format+`"`)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var csvWriter *csv.Writer
+		encoder := json.NewEncoder(w)
+		if format == "csv" {
+			csvWriter = csv.NewWriter(w)
+			csvWriter.Write(productCSVColumns)
+		}
+
+		var batch []models.Product
+		err := database.DB.FindInBatches(&batch, importBatchSize, func(tx *gorm.DB, _ int) error {
+			if err := withVariantStock(database.DB, batch); err != nil {
+				return err
+			}
+			for _, p := range batch {
+				if csvWriter != nil {
+					csvWriter.Write([]string{
+						p.Handle,
+						p.Title,
+						p.Description,
+						strconv.FormatFloat(p.Price, 'f', -1, 64),
+						strconv.Itoa(p.Stock),
+					})
+					continue
+				}
+				row := productImportRow{Handle: p.Handle, Title: &p.Title, Description: &p.Description, Price: &p.Price, Stock: &p.Stock}
+				if err := encoder.Encode(row); err != nil {
+					return err
+				}
+			}
+			if csvWriter != nil {
+				csvWriter.Flush()
+				if err := csvWriter.Error(); err != nil {
+					return err
+				}
+			}
+			// Push each batch to the client rather than buffering the catalog
+			return w.Flush()
+		}).Error
+		if err != nil {
+			log.Printf("product export: %v", err)
+		}
+	})
+	return nil
+}
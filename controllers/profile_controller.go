@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+var errInvalidEmailToken = errors.New("invalid email change token")
+var errEmailTaken = errors.New("email already registered")
+
+// currentUserRecord loads the models.User behind the request's access token
+func currentUserRecord(c *fiber.Ctx) (models.User, bool) {
+	var user models.User
+	claims, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+		return user, false
+	}
+	if err := database.DB.First(&user, claims.UserID).Error; err != nil {
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
+
+// GetMe returns the logged-in user's profile
+func GetMe(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// UpdateMe lets users change their display name
+func UpdateMe(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Name cannot be empty"})
+	}
+
+	if err := database.DB.Model(&user).Update("name", name).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update profile"})
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// ChangePassword verifies the current password, stores the new hash and signs
+// the user out everywhere. A fresh token pair is returned for this client.
+func ChangePassword(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+	if err := utils.ValidatePassword(input.NewPassword); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hash, err := hashPassword(input.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+
+	// The replacement session keeps the MFA status of the current one
+	claims, _ := middleware.CurrentUser(c)
+
+	var tokens tokenPair
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hash).Error; err != nil {
+			return err
+		}
+		if err := revokeUserSessions(tx, user.ID); err != nil {
+			return err
+		}
+		tokens, err = startSession(tx, user, claims.MFA)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Password changed", "data": tokens})
+}
+
+// RequestEmailChange starts an email change. The new address only takes
+// effect once the link sent to it is confirmed.
+func RequestEmailChange(c *fiber.Ctx) error {
+	user, ok := currentUserRecord(c)
+	if !ok {
+		return nil
+	}
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Password is incorrect"})
+	}
+	email, err := normalizeEmail(input.Email)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid email address"})
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start email change"})
+	}
+	change := models.EmailChange{
+		UserID:    user.ID,
+		NewEmail:  email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	}
+	if err := database.DB.Create(&change).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to start email change"})
+	}
+
+	sendMail(email, "Confirm your new GO-MART email",
+		"Use this link to confirm your new email address. It expires in 24 hours.\n\n"+appLink("/confirm-email", token))
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Confirmation sent to the new email address"})
+}
+
+// ConfirmEmailChange applies a pending email change using its token
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token is required"})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var change models.EmailChange
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(input.Token), time.Now()).
+			First(&change).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidEmailToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&change).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		// Following the link proves ownership of the new address
+		err = tx.Model(&models.User{}).Where("id = ?", change.UserID).
+			Updates(map[string]interface{}{"email": change.NewEmail, "email_verified_at": now}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errEmailTaken
+		}
+		return err
+	})
+
+	switch {
+	case err == nil:
+		return c.JSON(fiber.Map{"message": "Email updated"})
+	case errors.Is(err, errInvalidEmailToken):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired token"})
+	case errors.Is(err, errEmailTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already registered"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update email"})
+	}
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// GetRoles lists every role with its permissions
+func GetRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := database.DB.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve roles"})
+	}
+	return c.JSON(roles)
+}
+
+// SetRolePermissions replaces the permissions of a role, creating the role if needed
+func SetRolePermissions(c *fiber.Ctx) error {
+	var input struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	var perms []models.Permission
+	if len(input.Permissions) > 0 {
+		if err := database.DB.Where("name IN ?", input.Permissions).Find(&perms).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update role"})
+		}
+		if len(perms) != len(input.Permissions) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Unknown permission", "valid": models.AllPermissions})
+		}
+	}
+
+	role := models.Role{Name: c.Params("name")}
+	if err := database.DB.Where(models.Role{Name: role.Name}).FirstOrCreate(&role).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update role"})
+	}
+	if input.Description != "" {
+		role.Description = input.Description
+		database.DB.Model(&role).Update("description", role.Description)
+	}
+	if err := database.DB.Model(&role).Association("Permissions").Replace(perms); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update role"})
+	}
+	role.Permissions = perms
+
+	return c.JSON(role)
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// productSearchResult is a product with its search relevance and highlighted
+// text. Highlight and Snippet are HTML: product text is escaped, and only the
+// <mark> tags around matches are markup.
+type productSearchResult struct {
+	models.Product
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+	Snippet   string  `json:"snippet"`
+}
+
+// sqlEscapeHTML wraps a SQL text expression so that it evaluates to the text
+// with HTML special characters escaped
+func sqlEscapeHTML(expr string) string {
+	// '' is a quote inside a SQL string literal; & goes first so that the
+	// entities added later are not escaped again
+	for _, r := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}, {"''", "&#39;"}} {
+		expr = "replace(" + expr + ", '" + r[0] + "', '" + r[1] + "')"
+	}
+	return expr
+}
+
+// productSearchFrom matches products on the full-text index, falling back to
+// trigram word similarity on the title so that misspelled queries still find
+// results. Word similarity compares the query with the closest part of the
+// title rather than all of it, so one misspelled word can match a long title.
+const productSearchFrom = `FROM products, websearch_to_tsquery('english', @q) AS query
+	WHERE (products.search_vector @@ query OR @q <% products.title) AND products.archived_at IS NULL`
+
+// SearchProducts runs a ranked full-text search over product titles and descriptions
+func SearchProducts(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Query parameter q is required"})
+	}
+	page := parsePageParams(c)
+	args := map[string]interface{}{
+		"q":      q,
+		"limit":  page.Limit,
+		"offset": (page.Page - 1) * page.Limit,
+	}
+
+	var total int64
+	if err := database.DB.Raw(`SELECT count(*) `+productSearchFrom, args).Scan(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to search products"})
+	}
+
+	results := []productSearchResult{}
+	err := database.DB.Raw(`SELECT products.*,
+			ts_rank(products.search_vector, query) + word_similarity(@q, products.title) AS rank,
+			ts_headline('english', `+sqlEscapeHTML("products.title")+`, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS highlight,
+			ts_headline('english', `+sqlEscapeHTML("coalesce(products.description, '')")+`, query,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') AS snippet
+		`+productSearchFrom+`
+		ORDER BY rank DESC, products.product_id
+		LIMIT @limit OFFSET @offset`, args).Scan(&results).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to search products"})
+	}
+
+	return sendPage(c, results, page, total)
+}
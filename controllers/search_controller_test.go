@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestSearchHighlightsEscapeProductText(t *testing.T) {
+	requireDB(t)
+
+	product := models.Product{
+		Title:       `<img src=x onerror=alert(1)> Lamp`,
+		Description: `A "bright" <script>alert('lamp')</script> desk lamp & shade`,
+		Price:       10,
+	}
+	if err := database.DB.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/products/search", SearchProducts)
+
+	status, body := doRequest(t, app, http.MethodGet, "/products/search?q=lamp", "", nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("search returned %d: %s", status, body)
+	}
+	var page struct {
+		Data []productSearchResult `json:"data"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 1 {
+		t.Fatalf("search found %d products, want 1", len(page.Data))
+	}
+
+	result := page.Data[0]
+	for _, html := range []string{result.Highlight, result.Snippet} {
+		stripped := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(html)
+		if strings.ContainsAny(stripped, `<>"'`) {
+			t.Errorf("highlight carries unescaped product text: %s", html)
+		}
+		if !strings.Contains(html, "<mark>") {
+			t.Errorf("highlight does not mark the match: %s", html)
+		}
+	}
+	if !strings.Contains(result.Highlight, "&lt;img") {
+		t.Errorf("title highlight = %s, want the escaped tag", result.Highlight)
+	}
+}
+
+func TestSearchMatchesMisspelledWordsInLongTitles(t *testing.T) {
+	requireDB(t)
+
+	for _, title := range []string{"Brass desk lamp with shade", "Ceramic coffee mug"} {
+		if err := database.DB.Create(&models.Product{Title: title, Price: 10}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/products/search", SearchProducts)
+
+	status, body := doRequest(t, app, http.MethodGet, "/products/search?q=lampp", "", nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("search returned %d: %s", status, body)
+	}
+	var page struct {
+		Data []productSearchResult `json:"data"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 1 || page.Data[0].Title != "Brass desk lamp with shade" {
+		t.Errorf("search for a misspelled word found %+v, want only the lamp", page.Data)
+	}
+}
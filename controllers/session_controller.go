@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// tokenPair is returned by login and refresh
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// startSession creates a new refresh-token session for user and signs an access
+// token for it. mfa records whether the login passed a second factor.
+func startSession(tx *gorm.DB, user models.User, mfa bool) (tokenPair, error) {
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		ExpiresAt:        time.Now().Add(refreshTokenTTL),
+		MFAVerified:      mfa,
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return tokenPair{}, err
+	}
+
+	return signSession(user, session, refreshToken)
+}
+
+func signSession(user models.User, session models.Session, refreshToken string) (tokenPair, error) {
+	claims := middleware.NewClaims(user.ID, user.Email, user.Role, session.ID, accessTokenTTL)
+	claims.MFA = session.MFAVerified
+	accessToken, err := middleware.SignToken(claims)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeUserSessions revokes every active session of a user
+func revokeUserSessions(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RefreshToken exchanges a refresh token for a new access token and rotates the refresh token
+func RefreshToken(c *fiber.Ctx) error {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Refresh token is required"})
+	}
+
+	hash := utils.HashToken(input.RefreshToken)
+
+	var tokens tokenPair
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("refresh_token_hash = ?", hash).First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			return errInvalidRefreshToken
+		}
+
+		var user models.User
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			return errInvalidRefreshToken
+		}
+
+		refreshToken, err := utils.RandomToken(32)
+		if err != nil {
+			return err
+		}
+		session.PreviousTokenHash = session.RefreshTokenHash
+		session.RefreshTokenHash = utils.HashToken(refreshToken)
+		if err := tx.Save(&session).Error; err != nil {
+			return err
+		}
+
+		tokens, err = signSession(user, session, refreshToken)
+		return err
+	})
+
+	if errors.Is(err, errInvalidRefreshToken) {
+		// A rotated-out token being replayed means it leaked: kill its session
+		database.DB.Model(&models.Session{}).
+			Where("previous_token_hash = ? AND revoked_at IS NULL", hash).
+			Update("revoked_at", time.Now())
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to refresh token"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": tokens})
+}
+
+// Logout revokes the session the current access token belongs to
+func Logout(c *fiber.Ctx) error {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+	}
+
+	err := database.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ?", user.SessionID, user.UserID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to log out"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeUserSessions lets an admin sign a user out everywhere, as long as the
+// admin holds every permission the user does
+func RevokeUserSessions(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB)
+	if !ok || !canManageRole(c, user.Role) {
+		return nil
+	}
+
+	if err := revokeUserSessions(database.DB, user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke sessions"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
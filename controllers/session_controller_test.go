@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestRevokeUserSessionsRequiresTheTargetsPermissions(t *testing.T) {
+	requireDB(t)
+
+	admin, _ := createTestUser(t, "admin@example.com", "admin")
+	shopper, _ := createTestUser(t, "shopper@example.com", "user")
+	_, agentToken := createTestUser(t, "agent@example.com", "support_agent")
+
+	app := fiber.New()
+	app.Delete("/admin/users/:id/sessions", middleware.JWTProtected(), middleware.Require("users:write"), RevokeUserSessions)
+
+	path := fmt.Sprintf("/admin/users/%d/sessions", admin.ID)
+	if status, body := doRequest(t, app, http.MethodDelete, path, agentToken, nil); status != fiber.StatusForbidden {
+		t.Errorf("support agent signing out an admin got %d, want 403: %s", status, body)
+	}
+	var live int64
+	database.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", admin.ID).Count(&live)
+	if live == 0 {
+		t.Error("the admin's sessions were revoked")
+	}
+
+	path = fmt.Sprintf("/admin/users/%d/sessions", shopper.ID)
+	if status, body := doRequest(t, app, http.MethodDelete, path, agentToken, nil); status != fiber.StatusNoContent {
+		t.Errorf("support agent signing out a shopper got %d, want 204: %s", status, body)
+	}
+	database.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", shopper.ID).Count(&live)
+	if live != 0 {
+		t.Errorf("%d of the shopper's sessions are still live", live)
+	}
+}
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/mailer"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/notify"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	stockEventInterval    = 10 * time.Second
+	stockEventBatch       = 50
+	maxStockEventAttempts = 5
+	// stockEventLease is how long a worker may take to deliver a claimed batch
+	stockEventLease = 30 * time.Minute
+)
+
+// queueStockEvents records the alerts a stock movement triggers: low stock
+// when the balance falls to the reorder threshold, back in stock when the
+// sellable stock rises above zero. Stock arriving in an inactive warehouse
+// cannot be sold, so it never brings an item back in stock. Being written in
+// the movement's transaction, the events are only delivered if it commits.
+func queueStockEvents(tx *gorm.DB, movement *models.InventoryMovement, threshold int) error {
+	before := movement.BalanceAfter - movement.Quantity
+	after := movement.BalanceAfter
+	if threshold > 0 && before > threshold && after <= threshold {
+		event := models.StockEvent{Kind: models.StockEventLow, ProductID: movement.ProductID, VariantID: movement.VariantID, Stock: after, Threshold: threshold}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+	}
+
+	if movement.Quantity <= 0 {
+		return nil
+	}
+	var active int64
+	if err := tx.Model(&models.Warehouse{}).Where("id = ? AND active", *movement.WarehouseID).Count(&active).Error; err != nil {
+		return err
+	}
+	if active == 0 {
+		return nil
+	}
+	sellable, err := sellableStock(tx, movement.ProductID, movement.VariantID)
+	if err != nil {
+		return err
+	}
+	return queueBackInStock(tx, movement.ProductID, movement.VariantID, sellable-movement.Quantity, sellable)
+}
+
+// queueBackInStock records a back-in-stock event when the sellable stock of
+// a product or variant went from before to after and so became available
+func queueBackInStock(tx *gorm.DB, productID uint, variantID *uint, before, after int) error {
+	if before > 0 || after <= 0 {
+		return nil
+	}
+	return tx.Create(&models.StockEvent{Kind: models.StockEventBackInStock, ProductID: productID, VariantID: variantID, Stock: after}).Error
+}
+
+// StartStockAlerts delivers queued stock events in the background
+func StartStockAlerts() {
+	go func() {
+		for range time.Tick(stockEventInterval) {
+			if err := deliverStockEvents(); err != nil {
+				log.Printf("stock alerts: %v", err)
+			}
+		}
+	}()
+}
+
+// deliverStockEvents sends one batch of pending events. The batch is claimed
+// in a short transaction, with SKIP LOCKED so several app instances never
+// take the same events, and delivered outside it: each event is then marked
+// on its own, so one failure never causes others to be sent again. Failed
+// events are retried up to maxStockEventAttempts times.
+func deliverStockEvents() error {
+	events, err := claimStockEvents()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		updates := map[string]interface{}{"attempts": event.Attempts + 1, "claimed_until": nil}
+		if err := deliverStockEvent(database.DB, event); err != nil {
+			log.Printf("stock alerts: event %d: %v", event.ID, err)
+			updates["last_error"] = err.Error()
+			if event.Attempts+1 >= maxStockEventAttempts {
+				updates["processed_at"] = time.Now()
+			}
+		} else {
+			updates["processed_at"] = time.Now()
+		}
+		if err := database.DB.Model(&event).Updates(updates).Error; err != nil {
+			log.Printf("stock alerts: event %d: %v", event.ID, err)
+		}
+	}
+	return nil
+}
+
+// claimStockEvents takes up to stockEventBatch pending events that no other
+// worker holds, reserving them for stockEventLease. Events of a worker that
+// died mid-batch are picked up again once their lease runs out.
+func claimStockEvents() ([]models.StockEvent, error) {
+	var events []models.StockEvent
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("processed_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)", now).
+			Order("id").Limit(stockEventBatch).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		return tx.Model(&models.StockEvent{}).Where("id IN ?", ids).Update("claimed_until", now.Add(stockEventLease)).Error
+	})
+	return events, err
+}
+
+func deliverStockEvent(db *gorm.DB, event models.StockEvent) error {
+	var product models.Product
+	if err := db.Unscoped().First(&product, event.ProductID).Error; err != nil {
+		return err
+	}
+	name := product.Title
+	if event.VariantID != nil {
+		var variant models.Variant
+		if err := db.First(&variant, *event.VariantID).Error; err != nil {
+			// The variant was deleted; nobody needs to hear about it
+			return nil
+		}
+		name = fmt.Sprintf("%s (%s)", product.Title, variant.SKU)
+	}
+
+	switch event.Kind {
+	case models.StockEventLow:
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return notify.Default.Notify(ctx, notify.Alert{
+			Kind:      event.Kind,
+			Subject:   "Low stock: " + name,
+			Message:   fmt.Sprintf("%s is down to %d in stock (reorder threshold %d).", name, event.Stock, event.Threshold),
+			ProductID: event.ProductID,
+			VariantID: event.VariantID,
+			Stock:     event.Stock,
+			Threshold: event.Threshold,
+		})
+	case models.StockEventBackInStock:
+		if product.ArchivedAt.Valid {
+			return nil
+		}
+		return notifySubscribers(db, event, name)
+	}
+	return nil
+}
+
+// notifySubscribers emails everyone waiting for the product or variant and
+// marks their subscriptions as used. A subscription whose email could not be
+// sent stays pending, and the error makes the event retry it.
+func notifySubscribers(db *gorm.DB, event models.StockEvent, name string) error {
+	query := db.Where("product_id = ? AND notified_at IS NULL", event.ProductID)
+	if event.VariantID != nil {
+		query = query.Where("variant_id = ?", *event.VariantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+	var subscriptions []models.StockSubscription
+	if err := query.Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	link := appURL(fmt.Sprintf("/products/%d", event.ProductID))
+	var failed []error
+	for _, sub := range subscriptions {
+		var user models.User
+		if err := db.First(&user, sub.UserID).Error; err == nil {
+			err := mailer.Mail.Send(user.Email, "Back in stock: "+name,
+				fmt.Sprintf("Good news: %s is back in stock.\n\n%s", name, link))
+			if err != nil {
+				failed = append(failed, fmt.Errorf("subscription %d: %w", sub.ID, err))
+				continue
+			}
+		}
+		if err := db.Model(&sub).Update("notified_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// SubscribeBackInStock asks to be emailed when an out-of-stock product, or
+// the variant given as variant_id, is back in stock
+func SubscribeBackInStock(c *fiber.Ctx) error {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+	}
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var product models.Product
+	if err := database.DB.Preload("Variants").First(&product, productID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	var input struct {
+		VariantID *uint `json:"variant_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	if input.VariantID != nil {
+		found := false
+		for _, v := range product.Variants {
+			if v.ID == *input.VariantID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Variant does not belong to this product"})
+		}
+	} else if len(product.Variants) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Choose a variant of this product"})
+	}
+	// Stock held only in inactive warehouses cannot be ordered, so it does not count
+	stock, err := sellableStock(database.DB, product.ProductId, input.VariantID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to subscribe"})
+	}
+	if stock > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This item is in stock"})
+	}
+
+	subscription := models.StockSubscription{UserID: user.UserID, ProductID: product.ProductId, VariantID: input.VariantID}
+	query := database.DB.Where("user_id = ? AND product_id = ? AND notified_at IS NULL", user.UserID, product.ProductId)
+	if input.VariantID != nil {
+		query = query.Where("variant_id = ?", *input.VariantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+	err = query.First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = database.DB.Create(&subscription).Error
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to subscribe"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(subscription)
+}
+
+// UnsubscribeBackInStock cancels the user's pending back-in-stock requests for a product
+func UnsubscribeBackInStock(c *fiber.Ctx) error {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid JWT claims"})
+	}
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	err = database.DB.Where("user_id = ? AND product_id = ? AND notified_at IS NULL", user.UserID, productID).
+		Delete(&models.StockSubscription{}).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to unsubscribe"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
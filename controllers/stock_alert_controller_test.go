@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestStockInInactiveWarehousesIsNotSellable(t *testing.T) {
+	requireDB(t)
+
+	_, token := createTestUser(t, "waiting@example.com", "user")
+	product := createTestProduct(t, 0)
+	closed := models.Warehouse{Code: "CLOSED", Name: "Closed"}
+	if err := database.DB.Create(&closed).Error; err != nil {
+		t.Fatal(err)
+	}
+	receipt := models.InventoryMovement{ProductID: product.ProductId, WarehouseID: &closed.ID, Type: models.MovementReceipt, Quantity: 4, Reason: "test stock"}
+	if err := moveStock(database.DB, &receipt, systemActor); err != nil {
+		t.Fatal(err)
+	}
+
+	backInStock := func() int64 {
+		var events int64
+		database.DB.Model(&models.StockEvent{}).Where("product_id = ? AND kind = ?", product.ProductId, models.StockEventBackInStock).Count(&events)
+		return events
+	}
+	if n := backInStock(); n != 0 {
+		t.Errorf("a receipt into an inactive warehouse queued %d back-in-stock events", n)
+	}
+	if _, ok := listInStock(t)[product.ProductId]; ok {
+		t.Error("a product held only in an inactive warehouse is listed as in stock")
+	}
+
+	app := fiber.New()
+	app.Post("/products/:id/subscribe", middleware.JWTProtected(), SubscribeBackInStock)
+	app.Post("/warehouses/transfers", TransferStock)
+	path := fmt.Sprintf("/products/%d/subscribe", product.ProductId)
+	if status, body := doRequest(t, app, http.MethodPost, path, token, nil); status != fiber.StatusCreated {
+		t.Errorf("subscribing to a product nobody can order got %d: %s", status, body)
+	}
+
+	var main models.Warehouse
+	if err := database.DB.Where("code = ?", "MAIN").First(&main).Error; err != nil {
+		t.Fatal(err)
+	}
+	transfer := fiber.Map{"product_id": product.ProductId, "from_warehouse_id": closed.ID, "to_warehouse_id": main.ID, "quantity": 2}
+	if status, body := doRequest(t, app, http.MethodPost, "/warehouses/transfers", "", transfer); status != fiber.StatusCreated {
+		t.Fatalf("transfer returned %d: %s", status, body)
+	}
+	if n := backInStock(); n != 1 {
+		t.Errorf("moving stock into an active warehouse queued %d back-in-stock events, want 1", n)
+	}
+	if stock := listInStock(t)[product.ProductId]; stock != 4 {
+		t.Errorf("in-stock listing shows stock %d, want the 4 on hand", stock)
+	}
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+)
+
+// UserResponse is the public shape of a user; it never carries the password hash
+type UserResponse struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	// EmailVerifiedAt is nil until the user follows the verification link
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+}
+
+func newUserResponse(u models.User) UserResponse {
+	resp := UserResponse{
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Role:            u.Role,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+	if u.DeletedAt.Valid {
+		resp.DeletedAt = &u.DeletedAt.Time
+	}
+	return resp
+}
+
+// GetAllUsers lists users page by page, optionally filtered by ?q= on name or email
+func GetAllUsers(c *fiber.Ctx) error {
+	page := parsePageParams(c)
+
+	query := database.DB.Model(&models.User{})
+	if c.QueryBool("include_deleted") {
+		query = query.Unscoped()
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("email ILIKE ? OR name ILIKE ?", like, like)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve users"})
+	}
+
+	var users []models.User
+	if err := query.Scopes(page.scope).Order("id").Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve users"})
+	}
+
+	resp := make([]UserResponse, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, newUserResponse(u))
+	}
+	return sendPage(c, resp, page, total)
+}
+
+// GetUser returns a single user by ID
+func GetUser(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB.Unscoped())
+	if !ok {
+		return nil
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// UpdateUser lets an admin change a user's name or email. A new email has to
+// be verified again, so a verification link is mailed to it.
+func UpdateUser(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB)
+	if !ok || !canManageRole(c, user.Role) {
+		return nil
+	}
+
+	var input struct {
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		if strings.TrimSpace(*input.Name) == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Name cannot be empty"})
+		}
+		updates["name"] = strings.TrimSpace(*input.Name)
+	}
+	if input.Email != nil {
+		email, err := normalizeEmail(*input.Email)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid email address"})
+		}
+		updates["email"] = email
+		if !strings.EqualFold(email, user.Email) {
+			updates["email_verified_at"] = nil
+		}
+	}
+	if len(updates) == 0 {
+		return c.JSON(newUserResponse(user))
+	}
+
+	var token string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if _, changed := updates["email_verified_at"]; !changed {
+			return nil
+		}
+		user.EmailVerifiedAt = nil
+		var err error
+		token, err = issueEmailVerification(tx, user.ID)
+		return err
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already registered"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update user"})
+	}
+	if token != "" {
+		sendVerificationEmail(user.Email, token)
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// SetUserRole promotes or demotes a user. Existing sessions are revoked so the
+// new role takes effect on the next login.
+func SetUserRole(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB)
+	if !ok {
+		return nil
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Role == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid role"})
+	}
+	if err := database.DB.Where("name = ?", input.Role).First(&models.Role{}).Error; err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid role"})
+	}
+	if !canManageRole(c, user.Role) || !canManageRole(c, input.Role) {
+		return nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", input.Role).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update role"})
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// DeleteUser soft-deletes a user and signs them out everywhere
+func DeleteUser(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB)
+	if !ok || !canManageRole(c, user.Role) {
+		return nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete user"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RestoreUser reverses a soft delete
+func RestoreUser(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB.Unscoped())
+	if !ok || !canManageRole(c, user.Role) {
+		return nil
+	}
+
+	if err := database.DB.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to restore user"})
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	return c.JSON(newUserResponse(user))
+}
+
+// UnlockUser clears a login lockout so the user can sign in again immediately
+func UnlockUser(c *fiber.Ctx) error {
+	user, ok := findUserParam(c, database.DB)
+	if !ok || !canManageRole(c, user.Role) {
+		return nil
+	}
+
+	err := database.DB.Model(&user).Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil}).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to unlock user"})
+	}
+	return c.JSON(newUserResponse(user))
+}
+
+// canManageRole reports whether the caller holds every permission of role,
+// writing a 403 when it does not. Staff cannot edit, remove or promote users
+// to roles more privileged than their own.
+func canManageRole(c *fiber.Ctx, role string) bool {
+	granted, err := middleware.CallerPermissions(c)
+	var required []string
+	if err == nil {
+		required, err = middleware.RolePermissions(role)
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check permissions"})
+		return false
+	}
+
+	held := make(map[string]bool, len(granted))
+	for _, p := range granted {
+		held[p] = true
+	}
+	for _, p := range required {
+		if !held[p] {
+			c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You cannot manage users with permissions you do not hold"})
+			return false
+		}
+	}
+	return true
+}
+
+// findUserParam loads the user named by :id, writing an error response when it can't
+func findUserParam(c *fiber.Ctx, db *gorm.DB) (models.User, bool) {
+	var user models.User
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id < 1 {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+		return user, false
+	}
+	if err := db.First(&user, id).Error; err != nil {
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/middleware"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestUpdateUserEmailNeedsVerifyingAgain(t *testing.T) {
+	requireDB(t)
+
+	shopper, _ := createTestUser(t, "shopper@example.com", "user")
+	_, adminToken := createTestUser(t, "admin@example.com", "admin")
+
+	app := fiber.New()
+	app.Patch("/users/:id", middleware.JWTProtected(), middleware.Require("users:write"), UpdateUser)
+	path := fmt.Sprintf("/users/%d", shopper.ID)
+
+	if status, body := doRequest(t, app, http.MethodPatch, path, adminToken, fiber.Map{"email": "Shopper@Example.com"}); status != fiber.StatusOK {
+		t.Fatalf("PATCH %s returned %d: %s", path, status, body)
+	}
+	var reloaded models.User
+	database.DB.First(&reloaded, shopper.ID)
+	if reloaded.EmailVerifiedAt == nil {
+		t.Error("changing only the case of the email cleared its verification")
+	}
+
+	if status, body := doRequest(t, app, http.MethodPatch, path, adminToken, fiber.Map{"email": "someone-else@example.com"}); status != fiber.StatusOK {
+		t.Fatalf("PATCH %s returned %d: %s", path, status, body)
+	}
+	database.DB.First(&reloaded, shopper.ID)
+	if reloaded.Email != "someone-else@example.com" || reloaded.EmailVerifiedAt != nil {
+		t.Errorf("after the email change: email %q, verified at %v; want it unverified", reloaded.Email, reloaded.EmailVerifiedAt)
+	}
+	var links int64
+	database.DB.Model(&models.EmailVerification{}).Where("user_id = ? AND used_at IS NULL", shopper.ID).Count(&links)
+	if links != 1 {
+		t.Errorf("%d pending verification links, want 1", links)
+	}
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// variantInput is the body of variant create and update requests
+type variantInput struct {
+	SKU              *string            `json:"sku"`
+	Options          *map[string]string `json:"options"`
+	Price            *float64           `json:"price"`
+	Stock            *int               `json:"stock"`
+	ReorderThreshold *int               `json:"reorder_threshold"`
+}
+
+// findVariantParams loads the variant named by :variantId under product :id
+func findVariantParams(c *fiber.Ctx) (models.Variant, bool) {
+	var variant models.Variant
+	productID, err1 := strconv.Atoi(c.Params("id"))
+	variantID, err2 := strconv.Atoi(c.Params("variantId"))
+	if err1 != nil || err2 != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product or variant ID"})
+		return variant, false
+	}
+	if err := database.DB.Where("id = ? AND product_id = ?", variantID, productID).First(&variant).Error; err != nil {
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Variant not found"})
+		return variant, false
+	}
+	return variant, true
+}
+
+// CreateVariant adds a SKU to a product
+func CreateVariant(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var product models.Product
+	if err := database.DB.First(&product, productID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	var input variantInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.SKU == nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "SKU is required"})
+	}
+
+	variant := models.Variant{ProductID: product.ProductId}
+	if msg := applyVariantInput(&variant, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	// The initial stock opens the variant's inventory ledger
+	stock := variant.Stock
+	variant.Stock = 0
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		opening := models.InventoryMovement{ProductID: variant.ProductID, VariantID: &variant.ID, Type: models.MovementReceipt, Quantity: stock, Reason: "initial stock"}
+		if err := moveStock(tx, &opening, requestActor(c)); err != nil {
+			return err
+		}
+		variant.Stock = stock
+		return nil
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "SKU already in use"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create variant"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(variant)
+}
+
+// UpdateVariant changes the fields present in the body
+func UpdateVariant(c *fiber.Ctx) error {
+	variant, ok := findVariantParams(c)
+	if !ok {
+		return nil
+	}
+
+	var input variantInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if msg := applyVariantInput(&variant, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	// Stock changes are recorded as ledger adjustments; saving the other
+	// fields leaves the stock column alone
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("stock").Save(&variant).Error; err != nil {
+			return err
+		}
+		if input.Stock == nil {
+			return nil
+		}
+		var current models.Variant
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, variant.ID).Error; err != nil {
+			return err
+		}
+		adjustment := models.InventoryMovement{
+			ProductID: variant.ProductID,
+			VariantID: &variant.ID,
+			Type:      models.MovementAdjustment,
+			Quantity:  *input.Stock - current.Stock,
+			Reason:    "stock set by variant edit",
+		}
+		return moveStock(tx, &adjustment, requestActor(c))
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "SKU already in use"})
+	}
+	if errors.Is(err, errNegativeStock) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Stock reduction exceeds what the default warehouse holds"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update variant"})
+	}
+
+	return c.JSON(variant)
+}
+
+// DeleteVariant removes a variant, its back-in-stock subscriptions and any
+// cart lines holding it, re-totalling the carts they were in. Stock still
+// held is written off through the ledger, warehouse by warehouse, before the
+// stock levels go. Order history keeps the SKU copied at checkout.
+func DeleteVariant(c *fiber.Ctx) error {
+	variant, ok := findVariantParams(c)
+	if !ok {
+		return nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var cartIDs []uint
+		if err := tx.Model(&models.CartItem{}).Where("variant_id = ?", variant.ID).Distinct().Pluck("cart_id", &cartIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("variant_id = ?", variant.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if len(cartIDs) > 0 {
+			var carts []models.Cart
+			if err := tx.Find(&carts, cartIDs).Error; err != nil {
+				return err
+			}
+			for i := range carts {
+				if err := updateCartTotal(tx, &carts[i]); err != nil {
+					return err
+				}
+			}
+		}
+		var levels []models.StockLevel
+		if err := tx.Where("variant_id = ? AND quantity <> 0", variant.ID).Order("warehouse_id").Find(&levels).Error; err != nil {
+			return err
+		}
+		for _, level := range levels {
+			warehouseID := level.WarehouseID
+			writeOff := models.InventoryMovement{
+				ProductID:   variant.ProductID,
+				VariantID:   &variant.ID,
+				WarehouseID: &warehouseID,
+				Type:        models.MovementAdjustment,
+				Quantity:    -level.Quantity,
+				Reason:      "variant deleted",
+			}
+			if err := moveStock(tx, &writeOff, requestActor(c)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.StockLevel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.StockSubscription{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&variant).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete variant"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// applyVariantInput copies the provided fields onto variant, returning a
+// validation message when the input is not acceptable
+func applyVariantInput(variant *models.Variant, input variantInput) string {
+	if input.SKU != nil {
+		sku := strings.TrimSpace(*input.SKU)
+		if sku == "" {
+			return "SKU cannot be empty"
+		}
+		variant.SKU = sku
+	}
+	if input.Options != nil {
+		variant.Options = *input.Options
+	}
+	if input.Price != nil {
+		if *input.Price < 0 {
+			return "Price cannot be negative"
+		}
+		variant.Price = input.Price
+	}
+	if input.Stock != nil {
+		if *input.Stock < 0 {
+			return "Stock cannot be negative"
+		}
+		variant.Stock = *input.Stock
+	}
+	if input.ReorderThreshold != nil {
+		if *input.ReorderThreshold < 0 {
+			return "Reorder threshold cannot be negative"
+		}
+		variant.ReorderThreshold = *input.ReorderThreshold
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+func TestDeleteVariantRetotalsCarts(t *testing.T) {
+	requireDB(t)
+
+	user, _ := createTestUser(t, "shopper@example.com", "user")
+	plain := createTestProduct(t, 5)
+	sized := createTestProduct(t, 0)
+	price := 15.0
+	variant := models.Variant{ProductID: sized.ProductId, SKU: "TEE-L", Price: &price}
+	if err := database.DB.Create(&variant).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	cart := models.Cart{UserID: user.ID, Total: 40}
+	if err := database.DB.Create(&cart).Error; err != nil {
+		t.Fatal(err)
+	}
+	items := []models.CartItem{
+		{CartID: cart.ID, ProductID: plain.ProductId, Quantity: 1, Price: 10},
+		{CartID: cart.ID, ProductID: sized.ProductId, VariantID: &variant.ID, Quantity: 2, Price: 15},
+	}
+	if err := database.DB.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Delete("/products/:id/variants/:variantId", DeleteVariant)
+	path := fmt.Sprintf("/products/%d/variants/%d", sized.ProductId, variant.ID)
+	if status, body := doRequest(t, app, http.MethodDelete, path, "", nil); status != fiber.StatusNoContent {
+		t.Fatalf("DELETE %s returned %d: %s", path, status, body)
+	}
+
+	var reloaded models.Cart
+	if err := database.DB.First(&reloaded, cart.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Total != 10 {
+		t.Errorf("cart total after deleting the variant = %v, want 10", reloaded.Total)
+	}
+}
+
+func TestDeleteVariantWritesOffItsStock(t *testing.T) {
+	requireDB(t)
+
+	user, _ := createTestUser(t, "waiting@example.com", "user")
+	product := createTestProduct(t, 0)
+	variant := createTestVariant(t, product, "TEE-M", 7)
+	second := models.Warehouse{Code: "SECOND", Name: "Second", Active: true}
+	if err := database.DB.Create(&second).Error; err != nil {
+		t.Fatal(err)
+	}
+	receipt := models.InventoryMovement{ProductID: product.ProductId, VariantID: &variant.ID, WarehouseID: &second.ID, Type: models.MovementReceipt, Quantity: 3, Reason: "test stock"}
+	if err := moveStock(database.DB, &receipt, systemActor); err != nil {
+		t.Fatal(err)
+	}
+	subscription := models.StockSubscription{UserID: user.ID, ProductID: product.ProductId, VariantID: &variant.ID}
+	if err := database.DB.Create(&subscription).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Delete("/products/:id/variants/:variantId", DeleteVariant)
+	path := fmt.Sprintf("/products/%d/variants/%d", product.ProductId, variant.ID)
+	if status, body := doRequest(t, app, http.MethodDelete, path, "", nil); status != fiber.StatusNoContent {
+		t.Fatalf("DELETE %s returned %d: %s", path, status, body)
+	}
+
+	var levels, subscriptions int64
+	database.DB.Model(&models.StockLevel{}).Where("variant_id = ?", variant.ID).Count(&levels)
+	database.DB.Model(&models.StockSubscription{}).Where("variant_id = ?", variant.ID).Count(&subscriptions)
+	if levels != 0 || subscriptions != 0 {
+		t.Errorf("%d stock levels and %d subscriptions left for the deleted variant", levels, subscriptions)
+	}
+
+	var writeOffs []models.InventoryMovement
+	database.DB.Where("variant_id = ? AND reason = ?", variant.ID, "variant deleted").Order("warehouse_id").Find(&writeOffs)
+	if len(writeOffs) != 2 || writeOffs[0].Quantity != -7 || writeOffs[1].Quantity != -3 {
+		t.Errorf("write-offs = %+v, want -7 from the default warehouse and -3 from the second", writeOffs)
+	}
+	var ledger int
+	database.DB.Model(&models.InventoryMovement{}).Where("variant_id = ?", variant.ID).Select("coalesce(sum(quantity), 0)").Scan(&ledger)
+	if ledger != 0 {
+		t.Errorf("the variant's ledger sums to %d, want 0", ledger)
+	}
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+)
+
+var errWarehouseNotFound = errors.New("warehouse not found")
+
+// warehouseInput is the body of warehouse create and update requests
+type warehouseInput struct {
+	Code      *string  `json:"code"`
+	Name      *string  `json:"name"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Priority  *int     `json:"priority"`
+	Active    *bool    `json:"active"`
+	IsDefault *bool    `json:"is_default"`
+}
+
+// GetWarehouses lists warehouses in allocation priority order
+func GetWarehouses(c *fiber.Ctx) error {
+	page := parsePageParams(c)
+
+	var total int64
+	if err := database.DB.Model(&models.Warehouse{}).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch warehouses"})
+	}
+
+	warehouses := []models.Warehouse{}
+	if err := database.DB.Scopes(page.scope).Order("priority, id").Find(&warehouses).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch warehouses"})
+	}
+	return sendPage(c, warehouses, page, total)
+}
+
+// CreateWarehouse adds a warehouse. New warehouses are active unless the
+// request says otherwise.
+func CreateWarehouse(c *fiber.Ctx) error {
+	var input warehouseInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.Code == nil || input.Name == nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Code and name are required"})
+	}
+
+	warehouse := models.Warehouse{Active: true}
+	if msg := applyWarehouseInput(&warehouse, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&warehouse).Error; err != nil {
+			return err
+		}
+		return claimDefault(tx, warehouse)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Warehouse code already in use"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create warehouse"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(warehouse)
+}
+
+// UpdateWarehouse changes the provided fields of a warehouse. A warehouse
+// stops being the default only when another one is made the default.
+func UpdateWarehouse(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid warehouse ID"})
+	}
+	var warehouse models.Warehouse
+	if err := database.DB.First(&warehouse, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Warehouse not found"})
+	}
+
+	var input warehouseInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if input.IsDefault != nil && !*input.IsDefault && warehouse.IsDefault {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Make another warehouse the default instead"})
+	}
+	reactivated := !warehouse.Active && input.Active != nil && *input.Active
+	if msg := applyWarehouseInput(&warehouse, input); msg != "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": msg})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&warehouse).Error; err != nil {
+			return err
+		}
+		if reactivated {
+			if err := queueReactivatedStock(tx, warehouse.ID); err != nil {
+				return err
+			}
+		}
+		return claimDefault(tx, warehouse)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Warehouse code already in use"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update warehouse"})
+	}
+
+	return c.JSON(warehouse)
+}
+
+// queueReactivatedStock raises back-in-stock events for the items that a
+// warehouse, once active again, makes sellable
+func queueReactivatedStock(tx *gorm.DB, warehouseID uint) error {
+	var levels []models.StockLevel
+	if err := tx.Where("warehouse_id = ? AND quantity > 0", warehouseID).Find(&levels).Error; err != nil {
+		return err
+	}
+	for _, level := range levels {
+		sellable, err := sellableStock(tx, level.ProductID, level.VariantID)
+		if err != nil {
+			return err
+		}
+		if err := queueBackInStock(tx, level.ProductID, level.VariantID, sellable-level.Quantity, sellable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimDefault clears the default flag of every other warehouse when
+// warehouse is the default, so there is only ever one
+func claimDefault(tx *gorm.DB, warehouse models.Warehouse) error {
+	if !warehouse.IsDefault {
+		return nil
+	}
+	return tx.Model(&models.Warehouse{}).Where("id <> ? AND is_default", warehouse.ID).Update("is_default", false).Error
+}
+
+// applyWarehouseInput copies the provided fields onto warehouse, returning a
+// validation message when the input is not acceptable
+func applyWarehouseInput(warehouse *models.Warehouse, input warehouseInput) string {
+	if input.Code != nil {
+		code := strings.ToUpper(strings.TrimSpace(*input.Code))
+		if code == "" {
+			return "Code cannot be empty"
+		}
+		warehouse.Code = code
+	}
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return "Name cannot be empty"
+		}
+		warehouse.Name = name
+	}
+	if (input.Latitude == nil) != (input.Longitude == nil) {
+		return "Latitude and longitude must be set together"
+	}
+	if input.Latitude != nil {
+		if *input.Latitude < -90 || *input.Latitude > 90 || *input.Longitude < -180 || *input.Longitude > 180 {
+			return "Invalid latitude or longitude"
+		}
+		warehouse.Latitude, warehouse.Longitude = input.Latitude, input.Longitude
+	}
+	if input.Priority != nil {
+		warehouse.Priority = *input.Priority
+	}
+	if input.Active != nil {
+		warehouse.Active = *input.Active
+	}
+	if input.IsDefault != nil {
+		warehouse.IsDefault = *input.IsDefault
+	}
+	return ""
+}
+
+// GetProductStockLevels lists how much of a product, and of each of its
+// variants, every warehouse holds. ?variant_id= narrows it to one variant.
+func GetProductStockLevels(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+
+	query := database.DB.Preload("Warehouse").Where("product_id = ?", productID)
+	if v := c.Query("variant_id"); v != "" {
+		variantID, err := strconv.Atoi(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid variant_id"})
+		}
+		query = query.Where("variant_id = ?", variantID)
+	}
+
+	levels := []models.StockLevel{}
+	if err := query.Order("variant_id NULLS FIRST, warehouse_id").Find(&levels).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get stock levels"})
+	}
+	return c.JSON(levels)
+}
+
+// TransferStock moves stock of a product or variant from one warehouse to
+// another. The transfer is recorded as a pair of ledger movements and leaves
+// the product's total stock unchanged.
+func TransferStock(c *fiber.Ctx) error {
+	var input struct {
+		ProductID       uint   `json:"product_id"`
+		VariantID       *uint  `json:"variant_id"`
+		FromWarehouseID uint   `json:"from_warehouse_id"`
+		ToWarehouseID   uint   `json:"to_warehouse_id"`
+		Quantity        int    `json:"quantity"`
+		Reason          string `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	switch {
+	case input.Quantity <= 0:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Quantity must be positive"})
+	case input.FromWarehouseID == input.ToWarehouseID:
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Source and destination warehouses must differ"})
+	}
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		reason = "warehouse transfer"
+	}
+
+	var product models.Product
+	if err := database.DB.Unscoped().Preload("Variants").First(&product, input.ProductID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+	if input.VariantID == nil && len(product.Variants) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Choose a variant of this product"})
+	}
+
+	actor := requestActor(c)
+	movements := []models.InventoryMovement{
+		{ProductID: product.ProductId, VariantID: input.VariantID, WarehouseID: &input.FromWarehouseID, Type: models.MovementTransfer, Quantity: -input.Quantity, Reason: reason},
+		{ProductID: product.ProductId, VariantID: input.VariantID, WarehouseID: &input.ToWarehouseID, Type: models.MovementTransfer, Quantity: input.Quantity, Reason: reason},
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var warehouses []models.Warehouse
+		if err := tx.Where("id IN ?", []uint{input.FromWarehouseID, input.ToWarehouseID}).Find(&warehouses).Error; err != nil {
+			return err
+		}
+		if len(warehouses) != 2 {
+			return errWarehouseNotFound
+		}
+		for i := range movements {
+			if err := moveStock(tx, &movements[i], actor); err != nil {
+				return err
+			}
+		}
+
+		// Moving stock out of an inactive warehouse can make it sellable again
+		after, err := sellableStock(tx, product.ProductId, input.VariantID)
+		if err != nil {
+			return err
+		}
+		before := after
+		for _, w := range warehouses {
+			switch {
+			case !w.Active:
+			case w.ID == input.ToWarehouseID:
+				before -= input.Quantity
+			case w.ID == input.FromWarehouseID:
+				before += input.Quantity
+			}
+		}
+		return queueBackInStock(tx, product.ProductId, input.VariantID, before, after)
+	})
+
+	switch {
+	case err == nil:
+		return c.Status(fiber.StatusCreated).JSON(movements)
+	case errors.Is(err, errWarehouseNotFound):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Warehouse not found"})
+	case errors.Is(err, errStockItemNotFound):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Variant does not belong to this product"})
+	case errors.Is(err, errNegativeStock):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Source warehouse does not hold enough stock"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to transfer stock"})
+	}
+}
@@ -15,13 +15,46 @@ var DB *gorm.DB
 func ConnectDb(){
 
 	dsn:= os.Getenv("DB_DSN")
-	db , err:= gorm.Open(postgres.Open(dsn))
+	db , err:= gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err!=nil{
 		log.Fatal("Failed to connect to database")
 	}
 	
 	DB = db
 
-	DB.AutoMigrate(&models.User{},&models.Product{},&models.Cart{},&models.CartItem{},&models.Coupon{},models.Order{},models.OrderItem{})
+	if err := Migrate(); err != nil {
+		log.Fatal(err)
+	}
 	fmt.Println("connected to db")
-}
\ No newline at end of file
+}
+
+// Migrate brings the schema of DB up to date and seeds the data the API
+// relies on
+func Migrate() error {
+	// Runs first, as the users email index cannot be built over duplicates
+	if err := normalizeUserEmails(); err != nil {
+		return fmt.Errorf("failed to normalize user emails: %w", err)
+	}
+	if err := DB.AutoMigrate(&models.User{},&models.Product{},&models.Cart{},&models.CartItem{},&models.Coupon{},models.Order{},models.OrderItem{},&models.Session{},&models.EmailChange{},&models.PasswordReset{},&models.EmailVerification{},&models.Role{},&models.Permission{},&models.APIKey{},&models.UserIdentity{},&models.OIDCLoginState{},&models.RecoveryCode{},&models.Category{},&models.Variant{},&models.ProductImage{},&models.InventoryMovement{},&models.StockEvent{},&models.StockSubscription{},&models.Warehouse{},&models.StockLevel{}); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	if err := migrateProductSearch(); err != nil {
+		return fmt.Errorf("failed to set up product search: %w", err)
+	}
+	if err := backfillProductHandles(); err != nil {
+		return fmt.Errorf("failed to backfill product handles: %w", err)
+	}
+	if err := backfillInventoryLedger(); err != nil {
+		return fmt.Errorf("failed to backfill inventory ledger: %w", err)
+	}
+	if err := migrateWarehouses(); err != nil {
+		return fmt.Errorf("failed to set up warehouses: %w", err)
+	}
+	if err := seedRoles(); err != nil {
+		return fmt.Errorf("failed to seed roles: %w", err)
+	}
+	if err := promoteSuperAdmin(); err != nil {
+		return fmt.Errorf("failed to promote super admin: %w", err)
+	}
+	return nil
+}
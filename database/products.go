@@ -0,0 +1,59 @@
+package database
+
+// backfillProductHandles gives products created before handles existed a
+// stable handle, so every product can be matched by bulk imports
+func backfillProductHandles() error {
+	return DB.Exec(`UPDATE products SET handle = 'product-' || product_id WHERE handle = ''`).Error
+}
+
+// backfillInventoryLedger opens the ledger of products and variants that have
+// stock but no movements yet, so their stock equals the sum of their movements
+func backfillInventoryLedger() error {
+	statements := []string{
+		`INSERT INTO inventory_movements (product_id, type, quantity, balance_after, reason, actor_type, created_at)
+			SELECT p.product_id, 'adjustment', p.stock, p.stock, 'opening balance', 'system', now()
+			FROM products p
+			WHERE p.stock <> 0 AND NOT EXISTS (
+				SELECT 1 FROM inventory_movements m WHERE m.product_id = p.product_id AND m.variant_id IS NULL)`,
+		`INSERT INTO inventory_movements (product_id, variant_id, type, quantity, balance_after, reason, actor_type, created_at)
+			SELECT v.product_id, v.id, 'adjustment', v.stock, v.stock, 'opening balance', 'system', now()
+			FROM variants v
+			WHERE v.stock <> 0 AND NOT EXISTS (
+				SELECT 1 FROM inventory_movements m WHERE m.variant_id = v.id)`,
+	}
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWarehouses makes sure a default warehouse exists and books any
+// stock not yet held in a warehouse to it, so product and variant stock
+// always equals the sum of their stock levels
+func migrateWarehouses() error {
+	statements := []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_levels_item
+			ON stock_levels (warehouse_id, product_id, COALESCE(variant_id, 0))`,
+		`INSERT INTO warehouses (code, name, priority, active, is_default, created_at, updated_at)
+			SELECT 'MAIN', 'Main warehouse', 0, true, true, now(), now()
+			WHERE NOT EXISTS (SELECT 1 FROM warehouses)`,
+		`INSERT INTO stock_levels (warehouse_id, product_id, quantity, updated_at)
+			SELECT (SELECT id FROM warehouses WHERE is_default ORDER BY id LIMIT 1), p.product_id, p.stock, now()
+			FROM products p
+			WHERE p.stock > 0 AND NOT EXISTS (
+				SELECT 1 FROM stock_levels l WHERE l.product_id = p.product_id AND l.variant_id IS NULL)`,
+		`INSERT INTO stock_levels (warehouse_id, product_id, variant_id, quantity, updated_at)
+			SELECT (SELECT id FROM warehouses WHERE is_default ORDER BY id LIMIT 1), v.product_id, v.id, v.stock, now()
+			FROM variants v
+			WHERE v.stock > 0 AND NOT EXISTS (
+				SELECT 1 FROM stock_levels l WHERE l.variant_id = v.id)`,
+	}
+	for _, stmt := range statements {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pranavpatil6/go_mart/models"
+	"gorm.io/gorm"
+)
+
+// seedRoles makes sure every known permission and default role exists. A new
+// role gets its default permissions; a permission added in a later release is
+// granted to the default roles that list it. Other edits made through the API
+// are left alone.
+func seedRoles() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		perms := map[string]models.Permission{}
+		added := map[string]bool{}
+		for _, name := range models.AllPermissions {
+			perm := models.Permission{Name: name}
+			res := tx.Where(models.Permission{Name: name}).FirstOrCreate(&perm)
+			if res.Error != nil {
+				return res.Error
+			}
+			perms[name] = perm
+			added[name] = res.RowsAffected > 0
+		}
+
+		for name, permNames := range models.DefaultRoles {
+			role := models.Role{Name: name}
+			res := tx.Where(models.Role{Name: name}).FirstOrCreate(&role)
+			if res.Error != nil {
+				return res.Error
+			}
+			created := res.RowsAffected > 0
+			if created && name == "super_admin" {
+				// Admins could manage roles before super_admin took that over
+				if err := tx.Model(&models.User{}).Where("role = ?", "admin").Update("role", name).Error; err != nil {
+					return err
+				}
+			}
+
+			var grant []models.Permission
+			for _, p := range permNames {
+				if created || added[p] {
+					grant = append(grant, perms[p])
+				}
+			}
+			if len(grant) == 0 {
+				continue
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(grant); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// promoteSuperAdmin makes the account named by SUPER_ADMIN_EMAIL a
+// super_admin, so a new installation has someone who can assign roles
+func promoteSuperAdmin() error {
+	email := strings.ToLower(strings.TrimSpace(os.Getenv("SUPER_ADMIN_EMAIL")))
+	if email == "" {
+		return nil
+	}
+	return DB.Model(&models.User{}).Where("lower(email) = ?", email).Update("role", "super_admin").Error
+}
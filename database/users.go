@@ -0,0 +1,38 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// normalizeUserEmails lower-cases the emails of accounts registered while
+// emails were still compared case-sensitively, ahead of the unique index on
+// lower(email). Accounts whose emails differ only in case cannot be merged
+// safely, so they are reported and left for an operator to resolve.
+func normalizeUserEmails() error {
+	if !DB.Migrator().HasTable(&models.User{}) {
+		return nil
+	}
+
+	var duplicates []struct {
+		Email string
+		IDs   string
+	}
+	err := DB.Raw(`SELECT lower(email) AS email, string_agg(id::text, ', ' ORDER BY id) AS ids
+		FROM users GROUP BY lower(email) HAVING count(*) > 1 ORDER BY 1`).Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		lines := make([]string, len(duplicates))
+		for i, d := range duplicates {
+			lines[i] = fmt.Sprintf("%s (users %s)", d.Email, d.IDs)
+		}
+		return fmt.Errorf("accounts share an email that differs only in case; change or delete all but one of each: %s",
+			strings.Join(lines, "; "))
+	}
+
+	return DB.Exec(`UPDATE users SET email = lower(email) WHERE email <> lower(email)`).Error
+}
@@ -6,23 +6,40 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
+	"github.com/pranavpatil6/go_mart/controllers"
 	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/mailer"
+	"github.com/pranavpatil6/go_mart/notify"
+	"github.com/pranavpatil6/go_mart/oidc"
 	"github.com/pranavpatil6/go_mart/routes"
+	"github.com/pranavpatil6/go_mart/storage"
 )
 func main() {
 	godotenv.Load()
 
 	database.ConnectDb()
 
-	app := fiber.New()
+	mailer.Setup()
+	oidc.Setup()
+	storage.Setup()
+	notify.Setup()
 
-	app.Use(cors.New())
+	// Leave room for multi-image product uploads
+	app := fiber.New(fiber.Config{BodyLimit: 25 << 20})
+
+	// Let browser clients read the pagination and concurrency headers
+	app.Use(cors.New(cors.Config{ExposeHeaders: "ETag, Link, X-Total-Count"}))
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Welcome to Go-Mart!")
 	})
 
+	if local, ok := storage.Store.(*storage.LocalStore); ok {
+		app.Static(local.URLPrefix, local.Dir)
+	}
+
 	routes.SetupRoutes(app)
+	controllers.StartStockAlerts()
 
 	log.Fatal(app.Listen(":3000"))
 }
\ No newline at end of file
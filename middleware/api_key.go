@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+	"github.com/pranavpatil6/go_mart/utils"
+)
+
+// APIKeyHeader carries an integration's API key
+const APIKeyHeader = "X-API-Key"
+
+// lastUsedResolution limits how often last_used_at is written for a busy key
+const lastUsedResolution = time.Minute
+
+// CurrentAPIKey returns the API key that authenticated this request, if any
+func CurrentAPIKey(c *fiber.Ctx) (*models.APIKey, bool) {
+	key, ok := c.Locals("apiKey").(*models.APIKey)
+	return key, ok && key != nil
+}
+
+// JWTOrAPIKey authenticates a request with an X-API-Key header when one is
+// sent, and otherwise falls back to JWTProtected.
+func JWTOrAPIKey() fiber.Handler {
+	jwtAuth := JWTProtected()
+
+	return func(c *fiber.Ctx) error {
+		rawKey := c.Get(APIKeyHeader)
+		if rawKey == "" {
+			return jwtAuth(c)
+		}
+
+		var key models.APIKey
+		if err := database.DB.Preload("Permissions").Where("key_hash = ?", utils.HashToken(rawKey)).First(&key).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid API key",
+			})
+		}
+
+		now := time.Now()
+		if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "API key revoked or expired",
+			})
+		}
+
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+			database.DB.Model(&key).UpdateColumn("last_used_at", now)
+		}
+
+		c.Locals("apiKey", &key)
+		return c.Next()
+	}
+}
+
+// apiKeyGrants reports whether key is scoped to every one of perms
+func apiKeyGrants(key *models.APIKey, perms ...string) bool {
+	set := make(map[string]bool, len(key.Permissions))
+	for _, p := range key.Permissions {
+		set[p.Name] = true
+	}
+	for _, p := range perms {
+		if !set[p] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,15 +1,102 @@
 package middleware
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
 )
 
+// Claims is the typed payload carried by every GO-MART access token.
+type Claims struct {
+	UserID uint   `json:"id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	// SessionID links the access token to the refresh-token session it was issued for
+	SessionID uint `json:"sid"`
+	// MFA is true when the login passed a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// Purpose marks restricted tokens, such as the "mfa" token handed out
+	// between the password and TOTP steps. JWTProtected rejects them.
+	Purpose string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// NewClaims builds claims for a user session that expire after ttl, with a fresh token ID.
+func NewClaims(userID uint, email, role string, sessionID uint, ttl time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// SignToken signs claims with HS256 using JWT_SECRET.
+func SignToken(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret())
+}
+
+// ParseToken validates an HS256 token and returns its claims. Tokens signed
+// with any other algorithm, including "none", are rejected.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// CurrentUser returns the claims stored by JWTProtected for this request.
+func CurrentUser(c *fiber.Ctx) (*Claims, bool) {
+	claims, ok := c.Locals("user").(*Claims)
+	return claims, ok && claims != nil
+}
+
+// sessionActive reports whether the session behind an access token still exists
+// for that user and has been neither revoked nor expired.
+func sessionActive(sessionID, userID uint) bool {
+	var session models.Session
+	if err := database.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return false
+	}
+	return session.RevokedAt == nil && time.Now().Before(session.ExpiresAt)
+}
+
+func jwtSecret() []byte {
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
 func JWTProtected() fiber.Handler {
+	if len(jwtSecret()) == 0 {
+		log.Fatal("JWT_SECRET must be set")
+	}
+
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 
@@ -27,48 +114,26 @@ func JWTProtected() fiber.Handler {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := ParseToken(tokenString)
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
 			})
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		if claims.UserID == 0 || claims.SessionID == 0 || claims.Purpose != "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid token claims",
 			})
 		}
 
-		c.Locals("user", claims)
-		return c.Next()
-	}
-}
-
-func AdminOnly() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		userClaims, ok := c.Locals("user").(jwt.MapClaims)
-		if !ok {
+		if !sessionActive(claims.SessionID, claims.UserID) {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Unauthorized",
-			})
-		}
-
-		role, ok := userClaims["role"].(string)
-		if !ok {
-			fmt.Println("errorsss", ok, role, userClaims)
-		}
-		if !ok || role != "admin" {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "Admin access required",
+				"error": "Session has been revoked",
 			})
 		}
 
+		c.Locals("user", claims)
 		return c.Next()
 	}
 }
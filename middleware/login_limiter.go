@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// LoginLimiter throttles failed login attempts per client IP. Successful
+// logins are not counted, and blocked requests never reach bcrypt. The
+// limiter sets Retry-After on 429 responses.
+func LoginLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:                    10,
+		Expiration:             15 * time.Minute,
+		SkipSuccessfulRequests: true,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many failed login attempts, try again later",
+			})
+		},
+	})
+}
+
+// PasswordResetLimiter throttles forgot-password requests per client IP.
+// Every request counts, as the response never says whether a link was sent.
+func PasswordResetLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: 15 * time.Minute,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many password reset requests, try again later",
+			})
+		},
+	})
+}
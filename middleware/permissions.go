@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pranavpatil6/go_mart/database"
+	"github.com/pranavpatil6/go_mart/models"
+)
+
+// adminMFARequired reports whether permission-guarded routes demand an
+// MFA-verified login. It is on unless REQUIRE_ADMIN_MFA is "false".
+func adminMFARequired() bool {
+	return os.Getenv("REQUIRE_ADMIN_MFA") != "false"
+}
+
+// RolePermissions returns the names of the permissions granted to a role
+func RolePermissions(role string) ([]string, error) {
+	var names []string
+	err := database.DB.Model(&models.Permission{}).
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Where("roles.name = ?", role).
+		Pluck("permissions.name", &names).Error
+	return names, err
+}
+
+// CallerPermissions returns the permissions of the API key, or of the role of
+// the user, behind the request
+func CallerPermissions(c *fiber.Ctx) ([]string, error) {
+	if key, ok := CurrentAPIKey(c); ok {
+		names := make([]string, 0, len(key.Permissions))
+		for _, p := range key.Permissions {
+			names = append(names, p.Name)
+		}
+		return names, nil
+	}
+	if user, ok := CurrentUser(c); ok {
+		return RolePermissions(user.Role)
+	}
+	return nil, nil
+}
+
+// HasPermissions reports whether role holds every one of perms
+func HasPermissions(role string, perms ...string) (bool, error) {
+	granted, err := RolePermissions(role)
+	if err != nil {
+		return false, err
+	}
+	set := make(map[string]bool, len(granted))
+	for _, p := range granted {
+		set[p] = true
+	}
+	for _, p := range perms {
+		if !set[p] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Require allows the request through only when the caller's role, or the
+// scope of the API key used, grants all of perms. It must run after
+// JWTProtected or JWTOrAPIKey.
+func Require(perms ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if key, ok := CurrentAPIKey(c); ok {
+			if !apiKeyGrants(key, perms...) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error":    "API key is not scoped for this operation",
+					"required": perms,
+				})
+			}
+			return c.Next()
+		}
+
+		user, ok := CurrentUser(c)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		allowed, err := HasPermissions(user.Role, perms...)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check permissions",
+			})
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":    "Missing required permission",
+				"required": perms,
+			})
+		}
+
+		// Only callers who hold the permission learn that MFA is what they lack
+		if !user.MFA && adminMFARequired() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Two-factor authentication required for admin access",
+			})
+		}
+
+		return c.Next()
+	}
+}
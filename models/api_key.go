@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey authenticates a server-to-server integration. Only the hash of the
+// key is stored; Prefix lets admins tell keys apart.
+type APIKey struct {
+	gorm.Model
+	Name        string       `gorm:"not null" json:"name"`
+	Prefix      string       `gorm:"index;not null" json:"prefix"`
+	KeyHash     string       `gorm:"uniqueIndex;not null" json:"-"`
+	Permissions []Permission `gorm:"many2many:api_key_permissions" json:"permissions"`
+	CreatedByID uint         `json:"created_by_id"`
+	ExpiresAt   *time.Time   `json:"expires_at"`
+	RevokedAt   *time.Time   `json:"revoked_at"`
+	LastUsedAt  *time.Time   `json:"last_used_at"`
+}
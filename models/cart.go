@@ -17,6 +17,10 @@ type CartItem struct {
     CartID    uint    `gorm:"not null;index"`        
     ProductID uint    `gorm:"not null;index"`          
     Product   Product `gorm:"foreignKey:ProductID"`    
+    VariantID *uint    `gorm:"index"`
+    Variant   *Variant `gorm:"foreignKey:VariantID"`
     Quantity  int     `gorm:"not null"`
     Price     float64 `gorm:"not null"`
+    // Unavailable flags lines whose product has been archived since it was added
+    Unavailable bool `gorm:"-" json:",omitempty"`
 }
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Category groups products. Categories nest through ParentID.
+type Category struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"not null" json:"name"`
+	Slug        string     `gorm:"uniqueIndex;not null" json:"slug"`
+	Description string     `json:"description"`
+	ParentID    *uint      `gorm:"index" json:"parent_id"`
+	Children    []Category `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -14,5 +14,7 @@ type Coupon struct {
 	TimesUsed    int
 	UsageLimit   int
 	ProductId    int
-	Type         string
+	// CategoryId scopes the coupon to products in a category or its subcategories
+	CategoryId uint
+	Type       string
 }
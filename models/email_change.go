@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailChange is a pending email address change awaiting confirmation
+type EmailChange struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	NewEmail  string    `gorm:"not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account at an external OIDC provider
+type UserIdentity struct {
+	gorm.Model
+	UserID  uint   `gorm:"not null;index"`
+	Issuer  string `gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Subject string `gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Email   string
+}
+
+// OIDCLoginState holds the PKCE verifier and nonce of a sign-in in progress
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash    string    `gorm:"uniqueIndex;not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+}
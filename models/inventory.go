@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Inventory movement types. Quantity is positive for receipts and returns,
+// negative for sales and damage, and either for adjustments. Transfers move
+// stock between warehouses in pairs that cancel out.
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementReturn     = "return"
+	MovementAdjustment = "adjustment"
+	MovementDamage     = "damage"
+	MovementTransfer   = "transfer"
+)
+
+// InventoryMovement is one entry in the stock ledger of a product, or of one
+// of its variants when VariantID is set, at one warehouse. A product's stock
+// is the sum of its movements; BalanceAfter records the running total across
+// warehouses. ActorType is "user", "api_key" or "system".
+type InventoryMovement struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ProductID    uint      `gorm:"not null;index" json:"product_id"`
+	VariantID    *uint     `gorm:"index" json:"variant_id,omitempty"`
+	WarehouseID  *uint     `gorm:"index" json:"warehouse_id,omitempty"`
+	Type         string    `gorm:"not null" json:"type"`
+	Quantity     int       `gorm:"not null" json:"quantity"`
+	BalanceAfter int       `gorm:"not null" json:"balance_after"`
+	Reason       string    `json:"reason"`
+	OrderID      *uint     `gorm:"index" json:"order_id,omitempty"`
+	ActorType    string    `gorm:"not null" json:"actor_type"`
+	ActorID      *uint     `json:"actor_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
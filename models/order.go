@@ -12,6 +12,11 @@ type OrderItem struct {
 	Id        uint `json:"primaryKey"`
 	OrderId   uint
 	ProductId uint
-	Quantity  int
-	Price     float64
+	VariantId *uint
+	// SKU is copied from the variant at checkout so history survives variant changes
+	SKU string
+	// WarehouseId is the warehouse allocated to ship this item
+	WarehouseId *uint
+	Quantity    int
+	Price       float64
 }
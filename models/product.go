@@ -1,10 +1,26 @@
 package models
 
+import (
+    "gorm.io/gorm"
+)
 
+// Product is a catalog item. Deleting a product archives it: archived
+// products are hidden from the catalog but stay referenced by carts and orders.
 type Product struct {
-    ProductId   uint    `gorm:"primaryKey"`
-    Title       string
-    Description string
-    Price       float64
-    Stock       int
+    ProductId        uint    `gorm:"primaryKey"`
+    // Handle is a stable, unique SKU used to match products in bulk imports
+    Handle           string  `gorm:"not null;default:'';uniqueIndex:idx_products_handle,where:handle <> ''"`
+    Title            string
+    Description      string
+    Price            float64
+    // Stock is held across all warehouses, inactive ones included (see StockLevel)
+    Stock            int
+    // ReorderThreshold raises a low-stock alert when stock falls to it; 0 disables alerts
+    ReorderThreshold int     `gorm:"not null;default:0"`
+    // Version increases on every edit and is exposed as the product's ETag
+    Version          int     `gorm:"not null;default:1"`
+    ArchivedAt       gorm.DeletedAt `gorm:"index"`
+    Categories       []Category     `gorm:"many2many:product_categories;joinForeignKey:ProductID;joinReferences:CategoryID" json:",omitempty"`
+    Variants         []Variant      `gorm:"foreignKey:ProductID;references:ProductId" json:",omitempty"`
+    Images           []ProductImage `gorm:"foreignKey:ProductID;references:ProductId" json:",omitempty"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ProductImage is an uploaded product photo and its thumbnail. Position
+// orders a product's images, starting at 0 for the main image.
+type ProductImage struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ProductID    uint      `gorm:"not null;index" json:"product_id"`
+	Position     int       `gorm:"not null;default:0" json:"position"`
+	Key          string    `gorm:"not null" json:"-"`
+	ThumbnailKey string    `gorm:"not null" json:"-"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `gorm:"-" json:"url"`
+	ThumbnailURL string    `gorm:"-" json:"thumbnail_url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,56 @@
+package models
+
+// Role groups permissions; User.Role holds the role name
+type Role struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	Name        string       `gorm:"uniqueIndex;not null" json:"name"`
+	Description string       `json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+}
+
+// Permission is a single capability such as "products:write"
+type Permission struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"uniqueIndex;not null" json:"name"`
+}
+
+// AllPermissions lists every permission the API checks
+var AllPermissions = []string{
+	"products:write",
+	"coupons:write",
+	"orders:read",
+	"orders:write",
+	"users:read",
+	"users:write",
+	"users:roles",
+	"roles:write",
+	"apikeys:write",
+	"inventory:read",
+	"inventory:write",
+}
+
+// adminPermissions is everything but managing roles and role assignments,
+// which only super_admin may do. Existing admins become super_admins when the
+// role is first seeded; SUPER_ADMIN_EMAIL names one on a new installation.
+var adminPermissions = []string{
+	"products:write",
+	"coupons:write",
+	"orders:read",
+	"orders:write",
+	"users:read",
+	"users:write",
+	"apikeys:write",
+	"inventory:read",
+	"inventory:write",
+}
+
+// DefaultRoles are seeded on startup. Permissions are only assigned when a
+// role is first created, so later edits made through the API are kept.
+var DefaultRoles = map[string][]string{
+	"user":            {},
+	"admin":           adminPermissions,
+	"super_admin":     AllPermissions,
+	"catalog_manager": {"products:write", "coupons:write", "inventory:read", "inventory:write"},
+	"support_agent":   {"users:read", "users:write", "orders:read", "inventory:read"},
+	"finance":         {"orders:read", "orders:write", "coupons:write", "inventory:read"},
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session backs a refresh token. Access tokens carry the session ID, so
+// revoking the session invalidates both.
+type Session struct {
+	gorm.Model
+	UserID            uint      `gorm:"not null;index"`
+	RefreshTokenHash  string    `gorm:"uniqueIndex;not null"`
+	PreviousTokenHash string    `gorm:"index"`
+	ExpiresAt         time.Time `gorm:"not null"`
+	RevokedAt         *time.Time
+	// MFAVerified records that the login behind this session passed a second factor
+	MFAVerified bool `gorm:"not null;default:false"`
+}
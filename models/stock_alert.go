@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Stock event kinds
+const (
+	StockEventLow         = "low_stock"
+	StockEventBackInStock = "back_in_stock"
+)
+
+// StockEvent is queued in the same transaction as the stock movement that
+// caused it and delivered once that transaction has committed. A worker
+// claims an event until ClaimedUntil while it delivers it.
+type StockEvent struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Kind         string     `gorm:"not null" json:"kind"`
+	ProductID    uint       `gorm:"not null;index" json:"product_id"`
+	VariantID    *uint      `json:"variant_id,omitempty"`
+	Stock        int        `json:"stock"`
+	Threshold    int        `json:"threshold"`
+	Attempts     int        `gorm:"not null;default:0" json:"attempts"`
+	LastError    string     `json:"last_error,omitempty"`
+	ClaimedUntil *time.Time `json:"-"`
+	ProcessedAt  *time.Time `gorm:"index" json:"processed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// StockSubscription asks for an email when a product, or one of its
+// variants, is back in stock. It is used once: NotifiedAt is set when sent.
+type StockSubscription struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	ProductID  uint       `gorm:"not null;index" json:"product_id"`
+	VariantID  *uint      `json:"variant_id,omitempty"`
+	NotifiedAt *time.Time `json:"notified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
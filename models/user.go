@@ -1,11 +1,24 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Name string `json:"name"`
-	Email string `json:"email"`
-	Password string `json:"password"`
-	Role string `json:"role"`
-}
\ No newline at end of file
+	// Emails are stored lower-cased (older rows by a migration); the index on lower(email) keeps them unique
+	Email           string     `json:"email" gorm:"not null;uniqueIndex:idx_users_email_lower,expression:lower(email)"`
+	Password        string     `json:"-"`
+	Role            string     `json:"role"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// FailedLoginCount and LockedUntil drive the per-account login lockout
+	FailedLoginCount int        `json:"-" gorm:"not null;default:0"`
+	LockedUntil      *time.Time `json:"-"`
+	// TOTPSecret is set on enrollment; MFA is only enforced once MFAEnabledAt is set
+	TOTPSecret   string     `json:"-"`
+	TOTPLastStep int64      `json:"-"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Variant is a sellable SKU of a product, such as a size/colour combination.
+// Price, when set, overrides the product price; stock is tracked per variant
+// and, like product stock, includes inactive warehouses (see StockLevel).
+// A low-stock alert is raised when stock falls to ReorderThreshold (0 disables it).
+type Variant struct {
+	ID               uint              `gorm:"primaryKey" json:"id"`
+	ProductID        uint              `gorm:"not null;index" json:"product_id"`
+	SKU              string            `gorm:"uniqueIndex;not null" json:"sku"`
+	Options          map[string]string `gorm:"serializer:json;type:jsonb" json:"options"`
+	Price            *float64          `json:"price"`
+	Stock            int               `gorm:"not null;default:0" json:"stock"`
+	ReorderThreshold int               `gorm:"not null;default:0" json:"reorder_threshold"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// EffectivePrice is the variant's price, falling back to its product's
+func (v Variant) EffectivePrice(product Product) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
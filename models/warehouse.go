@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Warehouse is a location stock ships from. Coordinates, when set, let
+// checkout pick the warehouse nearest to the customer; Priority breaks ties
+// and orders warehouses when distance is unknown, lowest first. The default
+// warehouse receives stock that is not booked to a specific one. Checkout
+// only ships from active warehouses.
+type Warehouse struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Code      string    `gorm:"uniqueIndex;not null" json:"code"`
+	Name      string    `gorm:"not null" json:"name"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Priority  int       `gorm:"not null;default:0" json:"priority"`
+	Active    bool      `gorm:"not null" json:"active"`
+	IsDefault bool      `gorm:"not null" json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StockLevel is the stock of a product, or of one of its variants, held in
+// one warehouse. Product and variant Stock is the sum over all warehouses,
+// inactive ones included, so it is stock on hand. Only stock in active
+// warehouses can be sold, and only it counts as being in stock.
+type StockLevel struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	WarehouseID uint       `gorm:"not null;index" json:"warehouse_id"`
+	Warehouse   *Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	ProductID   uint       `gorm:"not null;index" json:"product_id"`
+	VariantID   *uint      `gorm:"index" json:"variant_id,omitempty"`
+	Quantity    int        `gorm:"not null;default:0" json:"quantity"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pranavpatil6/go_mart/mailer"
+)
+
+// Alert is an operational notice for staff, such as a product running low
+type Alert struct {
+	Kind      string `json:"kind"`
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+	ProductID uint   `json:"product_id"`
+	VariantID *uint  `json:"variant_id,omitempty"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
+
+// Notifier delivers alerts to staff
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Default is the notifier used by the application, chosen by Setup
+var Default Notifier = LogNotifier{}
+
+// Setup picks the notifier from NOTIFIER_DRIVER ("webhook", "email" or
+// "log", the default)
+func Setup() {
+	switch os.Getenv("NOTIFIER_DRIVER") {
+	case "webhook":
+		Default = &WebhookNotifier{
+			URL:    os.Getenv("NOTIFIER_WEBHOOK_URL"),
+			Secret: os.Getenv("NOTIFIER_WEBHOOK_SECRET"),
+		}
+	case "email":
+		Default = EmailNotifier{To: os.Getenv("NOTIFIER_EMAIL")}
+	default:
+		Default = LogNotifier{}
+	}
+}
+
+// LogNotifier writes alerts to the standard logger
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, alert Alert) error {
+	log.Printf("alert %s: %s", alert.Kind, alert.Message)
+	return nil
+}
+
+// EmailNotifier mails alerts to a staff address through mailer.Mail
+type EmailNotifier struct {
+	To string
+}
+
+func (n EmailNotifier) Notify(ctx context.Context, alert Alert) error {
+	return mailer.Mail.Send(n.To, alert.Subject, alert.Message)
+}
+
+// WebhookNotifier POSTs alerts as JSON. When Secret is set the body is signed
+// with HMAC-SHA256 in the X-GoMart-Signature header.
+type WebhookNotifier struct {
+	URL        string
+	Secret     string
+	HTTPClient *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-GoMart-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := n.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", n.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	stubClientID = "go-mart"
+	stubKeyID    = "stub-key"
+)
+
+// stubProvider is a minimal identity provider serving discovery, a JWKS and
+// a token endpoint that hands out IDToken
+type stubProvider struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	IDToken string
+	// Verifier is the PKCE verifier sent with the last token request
+	Verifier string
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                stub.URL,
+			AuthorizationEndpoint: stub.URL + "/authorize",
+			TokenEndpoint:         stub.URL + "/token",
+			JWKSURI:               stub.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": stubKeyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		stub.Verifier = r.PostForm.Get("code_verifier")
+		json.NewEncoder(w).Encode(map[string]string{"id_token": stub.IDToken})
+	})
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// sign issues an ID token for claims with the stub's key
+func (s *stubProvider) sign(t *testing.T, claims IDTokenClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = stubKeyID
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims are the claims of an ID token the provider accepts
+func (s *stubProvider) validClaims() IDTokenClaims {
+	now := time.Now()
+	return IDTokenClaims{
+		Email:         "shopper@example.com",
+		EmailVerified: true,
+		Nonce:         "expected-nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{stubClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+}
+
+func (s *stubProvider) provider() *Provider {
+	return &Provider{
+		Issuer:      s.URL,
+		ClientID:    stubClientID,
+		RedirectURL: "http://localhost:3000/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		HTTPClient:  s.Client(),
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	stub := newStubProvider(t)
+
+	raw, err := stub.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, stub.URL+"/authorize?") {
+		t.Fatalf("AuthCodeURL = %s, want the discovered authorization endpoint", raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"client_id":             stubClientID,
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := q.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*IDTokenClaims)
+		nonce   string
+		wantErr bool
+	}{
+		{name: "valid", nonce: "expected-nonce"},
+		{name: "nonce mismatch", nonce: "other-nonce", wantErr: true},
+		{
+			name:    "wrong audience",
+			edit:    func(c *IDTokenClaims) { c.Audience = jwt.ClaimStrings{"another-client"} },
+			nonce:   "expected-nonce",
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			edit:    func(c *IDTokenClaims) { c.Issuer = "https://attacker.example" },
+			nonce:   "expected-nonce",
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			edit:    func(c *IDTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) },
+			nonce:   "expected-nonce",
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			edit:    func(c *IDTokenClaims) { c.ExpiresAt = nil },
+			nonce:   "expected-nonce",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubProvider(t)
+			claims := stub.validClaims()
+			if tt.edit != nil {
+				tt.edit(&claims)
+			}
+			stub.IDToken = stub.sign(t, claims)
+
+			got, err := stub.provider().Exchange(context.Background(), "the-code", "the-verifier", tt.nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Exchange accepted an invalid ID token")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if got.Subject != "subject-1" || got.Email != "shopper@example.com" || !got.EmailVerified {
+				t.Errorf("Exchange returned %+v", got)
+			}
+			if stub.Verifier != "the-verifier" {
+				t.Errorf("token request sent code_verifier %q, want %q", stub.Verifier, "the-verifier")
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsForeignSigningKey(t *testing.T) {
+	stub := newStubProvider(t)
+	other := newStubProvider(t)
+	claims := stub.validClaims()
+	// Signed by a key the stub's JWKS does not publish
+	stub.IDToken = other.sign(t, claims)
+
+	if _, err := stub.provider().Exchange(context.Background(), "the-code", "the-verifier", "expected-nonce"); err == nil {
+		t.Fatal("Exchange accepted a token signed with an unpublished key")
+	}
+}
@@ -8,15 +8,91 @@ import (
 
 func SetupRoutes(app *fiber.App) {
 	app.Post("/register", controllers.Register)
-    app.Post("/login", controllers.Login)
-	app.Get("/users",controllers.GetAllUsers)
+    app.Post("/login", middleware.LoginLimiter(), controllers.Login)
+    app.Post("/auth/refresh", controllers.RefreshToken)
+    app.Post("/auth/logout", middleware.JWTProtected(), controllers.Logout)
+    app.Post("/auth/confirm-email", controllers.ConfirmEmailChange)
+    app.Post("/auth/forgot-password", middleware.PasswordResetLimiter(), controllers.ForgotPassword)
+    app.Post("/auth/reset-password", controllers.ResetPassword)
+    app.Post("/auth/verify-email", controllers.VerifyEmail)
+    app.Post("/auth/mfa/verify", middleware.LoginLimiter(), controllers.VerifyMFA)
+    app.Get("/auth/oidc/login", controllers.OIDCStart)
+    app.Get("/auth/oidc/callback", controllers.OIDCCallback)
+
+    // Profile
+    me := app.Group("/me", middleware.JWTProtected())
+    me.Get("/", controllers.GetMe)
+    me.Patch("/", controllers.UpdateMe)
+    me.Post("/password", controllers.ChangePassword)
+    me.Post("/email", controllers.RequestEmailChange)
+    me.Post("/verify-email", controllers.ResendVerification)
+    me.Post("/mfa/enroll", controllers.EnrollMFA)
+    me.Post("/mfa/confirm", controllers.ConfirmMFA)
+    me.Post("/mfa/recovery-codes", controllers.RegenerateRecoveryCodes)
+    me.Post("/mfa/disable", controllers.DisableMFA)
+
+    // Users
+    users := app.Group("/users", middleware.JWTProtected())
+    users.Get("/", middleware.Require("users:read"), controllers.GetAllUsers)
+    users.Get("/:id", middleware.Require("users:read"), controllers.GetUser)
+    users.Patch("/:id", middleware.Require("users:write"), controllers.UpdateUser)
+    users.Put("/:id/role", middleware.Require("users:roles"), controllers.SetUserRole)
+    users.Delete("/:id", middleware.Require("users:write"), controllers.DeleteUser)
+    users.Post("/:id/restore", middleware.Require("users:write"), controllers.RestoreUser)
+    users.Post("/:id/unlock", middleware.Require("users:write"), controllers.UnlockUser)
+
+    // Roles
+    roles := app.Group("/roles", middleware.JWTProtected(), middleware.Require("roles:write"))
+    roles.Get("/", controllers.GetRoles)
+    roles.Put("/:name", controllers.SetRolePermissions)
+
+    // API keys
+    apiKeys := app.Group("/api-keys", middleware.JWTProtected(), middleware.Require("apikeys:write"))
+    apiKeys.Post("/", controllers.CreateAPIKey)
+    apiKeys.Get("/", controllers.GetAPIKeys)
+    apiKeys.Delete("/:id", controllers.RevokeAPIKey)
 
     // Product
     app.Get("/products", controllers.GetAllProducts)
+    app.Get("/products/search", controllers.SearchProducts)
+    app.Get("/products/archived", middleware.JWTProtected(), middleware.Require("products:write"), controllers.GetArchivedProducts)
+    app.Get("/products/export", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.ExportProducts)
+    app.Post("/products/import", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.ImportProducts)
     app.Get("/products/:id", controllers.GetProduct)
-    app.Post("/products", middleware.JWTProtected(),middleware.AdminOnly(), controllers.CreateProduct)
-    app.Put("/products/:id", middleware.JWTProtected(),middleware.AdminOnly(), controllers.UpdateProduct)
-    app.Delete("/products/:id", middleware.JWTProtected(),middleware.AdminOnly(), controllers.DeleteProduct)
+    app.Post("/products", middleware.JWTProtected(), middleware.Require("products:write"), controllers.CreateProduct)
+    app.Put("/products/:id", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.UpdateProduct)
+    app.Patch("/products/:id", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.PatchProduct)
+    app.Delete("/products/:id", middleware.JWTProtected(), middleware.Require("products:write"), controllers.DeleteProduct)
+    app.Post("/products/:id/restore", middleware.JWTProtected(), middleware.Require("products:write"), controllers.RestoreProduct)
+
+    app.Put("/products/:id/categories", middleware.JWTProtected(), middleware.Require("products:write"), controllers.SetProductCategories)
+    app.Post("/products/:id/variants", middleware.JWTProtected(), middleware.Require("products:write"), controllers.CreateVariant)
+    app.Put("/products/:id/variants/:variantId", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.UpdateVariant)
+    app.Delete("/products/:id/variants/:variantId", middleware.JWTProtected(), middleware.Require("products:write"), controllers.DeleteVariant)
+    app.Post("/products/:id/images", middleware.JWTOrAPIKey(), middleware.Require("products:write"), controllers.UploadProductImages)
+    app.Put("/products/:id/images/order", middleware.JWTProtected(), middleware.Require("products:write"), controllers.ReorderProductImages)
+    app.Delete("/products/:id/images/:imageId", middleware.JWTProtected(), middleware.Require("products:write"), controllers.DeleteProductImage)
+
+    // Inventory
+    app.Get("/products/:id/inventory", middleware.JWTOrAPIKey(), middleware.Require("inventory:read"), controllers.GetStockMovements)
+    app.Post("/products/:id/inventory", middleware.JWTOrAPIKey(), middleware.Require("inventory:write"), controllers.CreateStockMovement)
+    app.Get("/products/:id/stock-levels", middleware.JWTOrAPIKey(), middleware.Require("inventory:read"), controllers.GetProductStockLevels)
+    app.Post("/products/:id/notify-me", middleware.JWTProtected(), controllers.SubscribeBackInStock)
+    app.Delete("/products/:id/notify-me", middleware.JWTProtected(), controllers.UnsubscribeBackInStock)
+
+    // Warehouses
+    warehouses := app.Group("/warehouses", middleware.JWTOrAPIKey())
+    warehouses.Get("/", middleware.Require("inventory:read"), controllers.GetWarehouses)
+    warehouses.Post("/", middleware.Require("inventory:write"), controllers.CreateWarehouse)
+    warehouses.Put("/:id", middleware.Require("inventory:write"), controllers.UpdateWarehouse)
+    warehouses.Post("/transfers", middleware.Require("inventory:write"), controllers.TransferStock)
+
+    // Categories
+    app.Get("/categories", controllers.GetCategoryTree)
+    app.Get("/categories/:id/products", controllers.GetCategoryProducts)
+    app.Post("/categories", middleware.JWTProtected(), middleware.Require("products:write"), controllers.CreateCategory)
+    app.Put("/categories/:id", middleware.JWTProtected(), middleware.Require("products:write"), controllers.UpdateCategory)
+    app.Delete("/categories/:id", middleware.JWTProtected(), middleware.Require("products:write"), controllers.DeleteCategory)
 
     // Cart
     cart := app.Group("/cart",middleware.JWTProtected())
@@ -26,15 +102,21 @@ func SetupRoutes(app *fiber.App) {
     cart.Post("/apply-coupon", controllers.ApplyCoupon)
 
     // Coupon
-    app.Post("/coupons", middleware.JWTProtected(), middleware.AdminOnly(), controllers.CreateCoupon)
+    app.Post("/coupons", middleware.JWTProtected(), middleware.Require("coupons:write"), controllers.CreateCoupon)
     app.Get("/coupons", controllers.GetCoupons)
     app.Get("/coupons/:code", controllers.GetCouponByCode)
-    app.Delete("/coupons/:id", middleware.JWTProtected(), middleware.AdminOnly(), controllers.DeleteCoupon)
+    app.Delete("/coupons/:id", middleware.JWTProtected(), middleware.Require("coupons:write"), controllers.DeleteCoupon)
 
     //Orders
-    orders := app.Group("/orders", middleware.JWTProtected())
-    orders.Post("/", controllers.CreateOrder)        
-    orders.Get("/", controllers.GetOrders)
+    orders := app.Group("/orders")
+    orders.Post("/", middleware.JWTProtected(), controllers.CreateOrder)
+    orders.Get("/", middleware.JWTProtected(), controllers.GetOrders)
+    orders.Patch("/:id/status", middleware.JWTOrAPIKey(), middleware.Require("orders:write"), controllers.UpdateOrderStatus)
+
+    // Admin
+    admin := app.Group("/admin", middleware.JWTProtected())
+    admin.Get("/users/:id/orders", middleware.Require("orders:read"), controllers.GetUserOrders)
+    admin.Delete("/users/:id/sessions", middleware.Require("users:write"), controllers.RevokeUserSessions)
 
 
 }
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubObject is an object held by stubS3
+type stubObject struct {
+	data        []byte
+	contentType string
+}
+
+// stubS3 is a MinIO-style S3 endpoint for one bucket. It checks the SigV4
+// signature of every request the way S3 does, from the request as received.
+type stubS3 struct {
+	*httptest.Server
+	bucket, region, accessKey, secretKey string
+
+	mu      sync.Mutex
+	objects map[string]stubObject
+}
+
+func newStubS3(t *testing.T) *stubS3 {
+	t.Helper()
+	stub := &stubS3{
+		bucket:    "media",
+		region:    "us-east-1",
+		accessKey: "minio",
+		secretKey: "minio-secret",
+		objects:   map[string]stubObject{},
+	}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *stubS3) store(secretKey string) *S3Store {
+	return &S3Store{
+		Endpoint:   s.URL,
+		Region:     s.region,
+		Bucket:     s.bucket,
+		AccessKey:  s.accessKey,
+		SecretKey:  secretKey,
+		HTTPClient: s.Client(),
+	}
+}
+
+func (s *stubS3) object(key string) (stubObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	return obj, ok
+}
+
+func (s *stubS3) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	if code := s.checkSignature(r, body); code != "" {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+		return
+	}
+
+	prefix := "/" + s.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[key] = stubObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		// S3 answers deletes of missing keys with 204 too
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature returns the S3 error code for a badly signed request, or ""
+func (s *stubS3) checkSignature(r *http.Request, body []byte) string {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	fields := map[string]string{}
+	for _, part := range strings.Split(auth, ", ") {
+		if name, value, ok := strings.Cut(part, "="); ok {
+			fields[name] = value
+		}
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.accessKey || credential[2] != s.region || credential[3] != "s3" {
+		return "InvalidAccessKeyId"
+	}
+
+	payloadSum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payloadSum[:])
+	if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+		return "XAmzContentSHA256Mismatch"
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-date", "x-amz-content-sha256"} {
+		if !containsString(signed, required) {
+			return "AccessDenied"
+		}
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), r.URL.RawQuery, canonicalHeaders.String(), fields["SignedHeaders"], payloadHash,
+	}, "\n")
+
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range append(credential[1:], stringToSign) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestS3StorePutAndDelete(t *testing.T) {
+	stub := newStubS3(t)
+	store := stub.store(stub.secretKey)
+	ctx := context.Background()
+
+	keys := []string{"products/1/photo.jpg", "products/1/odd name+plus.png"}
+	for _, key := range keys {
+		data := []byte("image bytes of " + key)
+		if err := store.Put(ctx, key, data, "image/jpeg"); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+		obj, ok := stub.object(key)
+		if !ok {
+			t.Fatalf("Put %q stored nothing", key)
+		}
+		if !bytes.Equal(obj.data, data) || obj.contentType != "image/jpeg" {
+			t.Errorf("Put %q stored %q as %q", key, obj.data, obj.contentType)
+		}
+	}
+
+	if err := store.Delete(ctx, keys[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := stub.object(keys[0]); ok {
+		t.Error("Delete left the object in place")
+	}
+	if err := store.Delete(ctx, "products/1/missing.jpg"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestS3StoreRejectedSignature(t *testing.T) {
+	stub := newStubS3(t)
+	store := stub.store("wrong-secret")
+
+	err := store.Put(context.Background(), "products/1/photo.jpg", []byte("data"), "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with a wrong secret returned %v, want SignatureDoesNotMatch", err)
+	}
+	if _, ok := stub.object("products/1/photo.jpg"); ok {
+		t.Error("object stored despite the bad signature")
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	store := &S3Store{Endpoint: "http://minio:9000", Bucket: "media"}
+	if got, want := store.URL("products/1/odd name.jpg"), "http://minio:9000/media/products/1/odd%20name.jpg"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+
+	store.PublicURL = "https://cdn.example.com"
+	if got, want := store.URL("products/1/a.jpg"), "https://cdn.example.com/products/1/a.jpg"; got != want {
+		t.Errorf("URL with PublicURL = %q, want %q", got, want)
+	}
+}
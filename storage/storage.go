@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// BlobStore saves and removes binary objects, such as product images, and
+// knows the public URL they are served from
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// Store is the blob store used by the application, chosen by Setup
+var Store BlobStore = &LocalStore{Dir: "uploads", URLPrefix: "/media"}
+
+// Setup picks the blob store from STORAGE_DRIVER ("s3" or "local", the default)
+func Setup() {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "s3":
+		Store = &S3Store{
+			Endpoint:  strings.TrimRight(os.Getenv("S3_ENDPOINT"), "/"),
+			Region:    envOr("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: strings.TrimRight(os.Getenv("S3_PUBLIC_URL"), "/"),
+		}
+	default:
+		Store = &LocalStore{
+			Dir:       envOr("STORAGE_DIR", "uploads"),
+			URLPrefix: "/media",
+		}
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	_ "embed"
+	"errors"
+	"strings"
+)
+
+const (
+	MinPasswordLength = 8
+	// MaxPasswordBytes is bcrypt's input limit; longer passwords are rejected rather than truncated
+	MaxPasswordBytes = 72
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong  = errors.New("password must be at most 72 bytes")
+	ErrPasswordCommon   = errors.New("password is too common, choose a less guessable one")
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	set := map[string]bool{}
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// ValidatePassword enforces the minimum password policy
+func ValidatePassword(password string) error {
+	switch {
+	case len([]rune(password)) < MinPasswordLength:
+		return ErrPasswordTooShort
+	case len(password) > MaxPasswordBytes:
+		return ErrPasswordTooLong
+	case commonPasswords[strings.ToLower(password)]:
+		return ErrPasswordCommon
+	}
+	return nil
+}